@@ -0,0 +1,199 @@
+package main
+
+import (
+	"cmp"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"gopkg.in/yaml.v3"
+)
+
+// mountAdmin returns the routes of the admin listener, which is for
+// operators and scrapers only and never exposed to clients. public is the
+// router of the public listener, listed by /routes.
+func (h *Handler) mountAdmin(public chi.Routes) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Handle("/metrics", h.metrics.Handler())
+	// net/http/pprof under /debug/pprof/ and expvar under /debug/vars.
+	r.Mount("/debug", middleware.Profiler())
+	r.Get("/log/level", h.GetLogLevel)
+	r.Put("/log/level", h.SetLogLevel)
+	r.Delete("/log/level", h.ResetLogLevel)
+	r.Get("/routes", h.ListRoutes(public))
+	r.Get("/config", h.GetConfig)
+	r.Get("/readyz", h.GetReadiness)
+	return r
+}
+
+func newAdminServer(mux http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
+
+// LogLevelRequest sets the lowest level logged, globally or for one
+// component.
+type LogLevelRequest struct {
+	Level string `json:"level" validate:"required,oneof=debug info warn error"`
+	// Component is empty for the global level.
+	Component string `json:"component" validate:"oneof=http grpc-client auth ratelimit"`
+	// RevertAfter, e.g. 15m, puts back the configured level once it has
+	// passed; empty keeps the new level until it is changed again.
+	RevertAfter string `json:"revert_after"`
+}
+
+// LogLevelResponse is the level of the global logger or a component.
+type LogLevelResponse struct {
+	Level string `json:"level"`
+	// RevertAt is when the configured level comes back, if it does.
+	RevertAt *time.Time `json:"revert_at,omitempty"`
+}
+
+// LogLevelsResponse is the global level and those of components that
+// have one of their own.
+type LogLevelsResponse struct {
+	LogLevelResponse
+	Components map[string]LogLevelResponse `json:"components"`
+}
+
+func newLogLevelResponse(s logging.LevelState) LogLevelResponse {
+	resp := LogLevelResponse{Level: strings.ToLower(s.Level.String())}
+	if !s.RevertAt.IsZero() {
+		at := s.RevertAt.UTC()
+		resp.RevertAt = &at
+	}
+	return resp
+}
+
+// GetLogLevel returns the levels logged at.
+func (h *Handler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	SendJsonResponse(w, http.StatusOK, h.logLevelsResponse())
+}
+
+func (h *Handler) logLevelsResponse() LogLevelsResponse {
+	global, components := h.logLevels.Snapshot()
+	resp := LogLevelsResponse{LogLevelResponse: newLogLevelResponse(global), Components: map[string]LogLevelResponse{}}
+	for component, s := range components {
+		resp.Components[component] = newLogLevelResponse(s)
+	}
+	return resp
+}
+
+// SetLogLevel changes the global level or that of a component until it
+// is reverted, changed again, or reloaded on SIGHUP.
+func (h *Handler) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req LogLevelRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+	var revertAfter time.Duration
+	if req.RevertAfter != "" {
+		d, err := time.ParseDuration(req.RevertAfter)
+		if err != nil || d <= 0 {
+			SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{Field: "revert_after", Description: "revert_after must be a positive duration, e.g. 15m"})
+			return
+		}
+		revertAfter = d
+	}
+	// The oneof rule only lets through levels slog knows.
+	var level slog.Level
+	_ = level.UnmarshalText([]byte(req.Level))
+	h.logLevels.Set(req.Component, level, revertAfter)
+	// Logged at warn so raising the level does not hide its own record.
+	slog.Warn("log level changed", logging.ComponentKey, req.Component, "to", level, "revert_after", revertAfter, "remote_addr", r.RemoteAddr)
+	SendJsonResponse(w, http.StatusOK, h.logLevelsResponse())
+}
+
+// ResetLogLevel puts back the configured level of the component named by
+// the component query parameter, or every configured level without it.
+func (h *Handler) ResetLogLevel(w http.ResponseWriter, r *http.Request) {
+	component := r.URL.Query().Get("component")
+	switch {
+	case component == "":
+		h.logLevels.UnsetAll()
+	case slices.Contains(config.LogComponents, component):
+		h.logLevels.Unset(component)
+	default:
+		SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{Field: "component", Description: "component must be one of " + strings.Join(config.LogComponents, ", ")})
+		return
+	}
+	slog.Warn("log level reset", logging.ComponentKey, component, "remote_addr", r.RemoteAddr)
+	SendJsonResponse(w, http.StatusOK, h.logLevelsResponse())
+}
+
+// RouteResponse is a route of the public listener.
+type RouteResponse struct {
+	Method  string `json:"method"`
+	Pattern string `json:"pattern"`
+	// Middlewares counts the middleware the route runs through, those of
+	// the whole router included.
+	Middlewares int `json:"middlewares"`
+}
+
+// ListRoutes lists the routes of public, sorted by pattern and method.
+func (h *Handler) ListRoutes(public chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var routes []RouteResponse
+		err := chi.Walk(public, func(method, route string, _ http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+			routes = append(routes, RouteResponse{Method: method, Pattern: route, Middlewares: len(middlewares)})
+			return nil
+		})
+		if err != nil {
+			SendProblemResponse(w, r, ErrCodeInternal, "")
+			return
+		}
+		slices.SortFunc(routes, func(a, b RouteResponse) int {
+			return cmp.Or(cmp.Compare(a.Pattern, b.Pattern), cmp.Compare(a.Method, b.Method))
+		})
+		SendJsonResponse(w, http.StatusOK, routes)
+	}
+}
+
+// GetConfig returns the configuration in effect as YAML, in the layout of
+// the config file, with secrets masked.
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	out, err := yaml.Marshal(h.cfg.Masked())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error encoding config", "err", err)
+		SendProblemResponse(w, r, ErrCodeInternal, "")
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(out)
+}
+
+// listenAdmin listens on the admin address, removing the socket file a
+// previous run left behind. Sockets are only usable by the owner and
+// group of the process.
+func listenAdmin(cfg config.AdminConfig) (net.Listener, error) {
+	network, addr := cfg.Listen()
+	if network != "unix" {
+		return net.Listen(network, addr)
+	}
+	if fi, err := os.Stat(addr); err == nil && fi.Mode().Type() == fs.ModeSocket {
+		if err := os.Remove(addr); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr, 0o660); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
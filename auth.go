@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	pb "github.com/InstaUpload/common/api"
+	"github.com/InstaUpload/gateway/session"
+)
+
+type TokenResponse struct {
+	AccessToken           string     `json:"access_token"`
+	TokenType             string     `json:"token_type"`
+	ExpiresIn             int64      `json:"expires_in"`
+	ExpiresAt             time.Time  `json:"expires_at"`
+	RefreshToken          string     `json:"refresh_token,omitempty"`
+	RefreshTokenExpiresIn int64      `json:"refresh_token_expires_in,omitempty"`
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+}
+
+type RefreshTokenRequest struct {
+	// RefreshToken may be left out when it is sent in the refresh cookie.
+	RefreshToken string `json:"refresh_token" validate:"max=256"`
+}
+
+// tokenExpiry returns the exp claim of token when it is a JWT, otherwise
+// now plus fallback. The signature is not checked, the result is only
+// reported to the client.
+func tokenExpiry(token string, now time.Time, fallback time.Duration) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) == 3 {
+		if payload, err := base64.RawURLEncoding.DecodeString(parts[1]); err == nil {
+			var claims struct {
+				Exp int64 `json:"exp"`
+			}
+			if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+				return time.Unix(claims.Exp, 0)
+			}
+		}
+	}
+	return now.Add(fallback)
+}
+
+func newSessionTokenResponse(p session.Pair, now time.Time) TokenResponse {
+	refreshExpiresAt := p.RefreshExpiresAt.UTC()
+	return TokenResponse{
+		AccessToken:           p.AccessToken,
+		TokenType:             "Bearer",
+		ExpiresIn:             int64(p.AccessExpiresAt.Sub(now).Seconds()),
+		ExpiresAt:             p.AccessExpiresAt.UTC(),
+		RefreshToken:          p.RefreshToken,
+		RefreshTokenExpiresIn: int64(p.RefreshExpiresAt.Sub(now).Seconds()),
+		RefreshTokenExpiresAt: &refreshExpiresAt,
+	}
+}
+
+func newTokenResponse(token string, now time.Time, fallback time.Duration) TokenResponse {
+	expiresAt := tokenExpiry(token, now, fallback)
+	return TokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(expiresAt.Sub(now).Seconds()),
+		ExpiresAt:   expiresAt.UTC(),
+	}
+}
+
+var sameSiteModes = map[string]http.SameSite{
+	"strict": http.SameSiteStrictMode,
+	"lax":    http.SameSiteLaxMode,
+	"none":   http.SameSiteNoneMode,
+}
+
+// refreshCookiePath limits the refresh token cookie to the refresh endpoint.
+const refreshCookiePath = "/v1/users/token"
+
+// setTokenCookie stores the access token, and the refresh token if there is
+// one, in Secure, HttpOnly cookies when the cookie is enabled in the config.
+func (h *Handler) setTokenCookie(w http.ResponseWriter, token TokenResponse) {
+	c := h.cfg.Auth.Cookie
+	if !c.Enabled {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.Name,
+		Value:    token.AccessToken,
+		Domain:   c.Domain,
+		Path:     c.Path,
+		Expires:  token.ExpiresAt,
+		MaxAge:   int(token.ExpiresIn),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSiteModes[c.SameSite],
+	})
+	if token.RefreshToken == "" {
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.RefreshName,
+		Value:    token.RefreshToken,
+		Domain:   c.Domain,
+		Path:     refreshCookiePath,
+		Expires:  *token.RefreshTokenExpiresAt,
+		MaxAge:   int(token.RefreshTokenExpiresIn),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSiteModes[c.SameSite],
+	})
+}
+
+// clearTokenCookies expires the access and refresh token cookies.
+func (h *Handler) clearTokenCookies(w http.ResponseWriter) {
+	c := h.cfg.Auth.Cookie
+	if !c.Enabled {
+		return
+	}
+	for name, path := range map[string]string{c.Name: c.Path, c.RefreshName: refreshCookiePath} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Domain:   c.Domain,
+			Path:     path,
+			MaxAge:   -1,
+			Secure:   true,
+			HttpOnly: true,
+			SameSite: sameSiteModes[c.SameSite],
+		})
+	}
+}
+
+// refreshToken returns the refresh token from the request body, falling
+// back to the refresh cookie when it is enabled. The body is optional; if
+// it is sent but invalid the problem response is sent and ok is false.
+func (h *Handler) refreshToken(w http.ResponseWriter, r *http.Request) (token string, ok bool) {
+	var req RefreshTokenRequest
+	if r.ContentLength != 0 && !decodeJSON(w, r, &req) {
+		return "", false
+	}
+	if req.RefreshToken == "" && h.cfg.Auth.Cookie.Enabled {
+		if c, err := r.Cookie(h.cfg.Auth.Cookie.RefreshName); err == nil {
+			req.RefreshToken = c.Value
+		}
+	}
+	return req.RefreshToken, true
+}
+
+// bearerToken returns the token from the Authorization header, falling back
+// to the access token cookie when it is enabled. ok is false when the
+// request carries no usable credentials.
+func (h *Handler) bearerToken(r *http.Request) (token string, ok bool) {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+			return "", false
+		}
+		return parts[1], true
+	}
+	if h.cfg.Auth.Cookie.Enabled {
+		if c, err := r.Cookie(h.cfg.Auth.Cookie.Name); err == nil && c.Value != "" {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+// loginUserID returns the ID of the user a freshly issued token belongs to,
+// reading it from the token when it can be verified locally.
+func (h *Handler) loginUserID(ctx context.Context, token string) (int64, error) {
+	if h.jwt != nil {
+		if claims, err := h.jwt.Verify(ctx, token); err == nil {
+			return claims.User.Id, nil
+		}
+	}
+	user, err := h.userClient.AuthUser(ctx, &pb.AuthUserRequest{Token: token})
+	if err != nil {
+		return 0, err
+	}
+	return user.Id, nil
+}
@@ -0,0 +1,229 @@
+// Package authcache caches AuthUser responses of the user service so that
+// authenticated requests do not each cost a gRPC round trip.
+//
+// Entries are keyed by token hash, expire after a fixed TTL or when the
+// token itself expires, whichever is first, and the least recently used
+// entry is evicted when the cache is full. Concurrent lookups of the same
+// uncached token share a single AuthUser call, whose answer is dropped if
+// its token or user is invalidated while it is in flight.
+package authcache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	pb "github.com/InstaUpload/common/api"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+)
+
+// FetchFunc asks the user service for the user behind a token.
+type FetchFunc func(ctx context.Context) (*pb.AuthUserResponse, error)
+
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	lru        *list.List
+	byUser     map[int64]map[string]struct{}
+	maxEntries int
+	ttl        time.Duration
+	group      singleflight.Group
+	now        func() time.Time
+	// seq counts invalidations. While fetches are in flight, the keys and
+	// users invalidated are recorded with it, so a fetch that started
+	// before an invalidation of its own key or user does not store a
+	// stale answer, and fetches for everyone else still do.
+	seq          uint64
+	fetching     int
+	invalidKeys  map[string]uint64
+	invalidUsers map[int64]uint64
+
+	hits          atomic.Uint64
+	misses        atomic.Uint64
+	coalesced     atomic.Uint64
+	evictions     atomic.Uint64
+	invalidations atomic.Uint64
+}
+
+type entry struct {
+	key       string
+	user      *pb.AuthUserResponse
+	expiresAt time.Time
+}
+
+// Stats is a snapshot of the cache counters.
+type Stats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Coalesced counts misses that shared their AuthUser call with at
+	// least one other request.
+	Coalesced     uint64 `json:"coalesced"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Size          int    `json:"size"`
+}
+
+// HitRate is the share of lookups answered from the cache.
+func (s Stats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+func New(maxEntries int, ttl time.Duration) *Cache {
+	return &Cache{
+		entries:      map[string]*list.Element{},
+		lru:          list.New(),
+		byUser:       map[int64]map[string]struct{}{},
+		invalidKeys:  map[string]uint64{},
+		invalidUsers: map[int64]uint64{},
+		maxEntries:   maxEntries,
+		ttl:          ttl,
+		now:          time.Now,
+	}
+}
+
+// Get returns the cached user for key or calls fetch, sharing the call with
+// concurrent Gets of the same key. Errors are never cached. The returned
+// message is a copy the caller may keep.
+func (c *Cache) Get(ctx context.Context, key string, tokenExpiresAt time.Time, fetch FetchFunc) (*pb.AuthUserResponse, error) {
+	if user, ok := c.lookup(key); ok {
+		c.hits.Add(1)
+		return user, nil
+	}
+	c.misses.Add(1)
+
+	// The shared call must not fail for everyone when the request that
+	// started it goes away, so it runs without the caller's cancellation
+	// and each caller waits only as long as its own context allows.
+	ch := c.group.DoChan(key, func() (any, error) {
+		c.mu.Lock()
+		start := c.seq
+		c.fetching++
+		c.mu.Unlock()
+		user, err := fetch(context.WithoutCancel(ctx))
+		c.store(key, user, tokenExpiresAt, start)
+		if err != nil {
+			return nil, err
+		}
+		return user, nil
+	})
+	select {
+	case res := <-ch:
+		if res.Shared {
+			c.coalesced.Add(1)
+		}
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return proto.Clone(res.Val.(*pb.AuthUserResponse)).(*pb.AuthUserResponse), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Invalidate drops the entry for key, e.g. after the token was revoked.
+func (c *Cache) Invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetching > 0 {
+		c.seq++
+		c.invalidKeys[key] = c.seq
+	}
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+		c.invalidations.Add(1)
+	}
+	c.group.Forget(key)
+}
+
+// InvalidateUser drops every entry of userID, e.g. after their role changed.
+func (c *Cache) InvalidateUser(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetching > 0 {
+		c.seq++
+		c.invalidUsers[userID] = c.seq
+	}
+	for key := range c.byUser[userID] {
+		c.removeLocked(c.entries[key])
+		c.invalidations.Add(1)
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return Stats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Coalesced:     c.coalesced.Load(),
+		Evictions:     c.evictions.Load(),
+		Invalidations: c.invalidations.Load(),
+		Size:          size,
+	}
+}
+
+func (c *Cache) lookup(key string) (*pb.AuthUserResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !c.now().Before(e.expiresAt) {
+		c.removeLocked(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return proto.Clone(e.user).(*pb.AuthUserResponse), true
+}
+
+// store ends a fetch that started at invalidation start, keeping user
+// unless the fetch failed or its key or user was invalidated since.
+func (c *Cache) store(key string, user *pb.AuthUserResponse, tokenExpiresAt time.Time, start uint64) {
+	expiresAt := c.now().Add(c.ttl)
+	if !tokenExpiresAt.IsZero() && tokenExpiresAt.Before(expiresAt) {
+		expiresAt = tokenExpiresAt
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stale := user == nil || c.invalidKeys[key] > start || c.invalidUsers[user.Id] > start
+	// Once nothing is in flight the invalidations recorded can not matter
+	// anymore.
+	if c.fetching--; c.fetching == 0 {
+		clear(c.invalidKeys)
+		clear(c.invalidUsers)
+	}
+	if stale {
+		return
+	}
+	if el, ok := c.entries[key]; ok {
+		c.removeLocked(el)
+	}
+	for c.lru.Len() >= c.maxEntries {
+		c.removeLocked(c.lru.Back())
+		c.evictions.Add(1)
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, user: user, expiresAt: expiresAt})
+	if c.byUser[user.Id] == nil {
+		c.byUser[user.Id] = map[string]struct{}{}
+	}
+	c.byUser[user.Id][key] = struct{}{}
+}
+
+func (c *Cache) removeLocked(el *list.Element) {
+	e := c.lru.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	delete(c.byUser[e.user.Id], e.key)
+	if len(c.byUser[e.user.Id]) == 0 {
+		delete(c.byUser, e.user.Id)
+	}
+}
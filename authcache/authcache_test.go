@@ -0,0 +1,218 @@
+package authcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pb "github.com/InstaUpload/common/api"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestCache(maxEntries int, ttl time.Duration) (*Cache, *testClock) {
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c := New(maxEntries, ttl)
+	c.now = clock.now
+	return c, clock
+}
+
+// userService answers fetches with a user, counting the calls.
+type userService struct {
+	calls atomic.Int64
+}
+
+func (s *userService) fetch(id int64) FetchFunc {
+	return func(context.Context) (*pb.AuthUserResponse, error) {
+		s.calls.Add(1)
+		return &pb.AuthUserResponse{Id: id}, nil
+	}
+}
+
+func get(t *testing.T, c *Cache, key string, fetch FetchFunc) *pb.AuthUserResponse {
+	t.Helper()
+	user, err := c.Get(context.Background(), key, time.Time{}, fetch)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	return user
+}
+
+// blockedFetch starts a Get of key whose fetch waits until release is
+// called, returning once the fetch has started. release returns when the
+// Get does.
+func blockedFetch(t *testing.T, c *Cache, key string, id int64) (release func()) {
+	t.Helper()
+	started, unblock, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		c.Get(context.Background(), key, time.Time{}, func(context.Context) (*pb.AuthUserResponse, error) {
+			close(started)
+			<-unblock
+			return &pb.AuthUserResponse{Id: id}, nil
+		})
+	}()
+	<-started
+	return func() { close(unblock); <-finished }
+}
+
+func TestGetCoalesces(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+	var calls atomic.Int64
+	release := make(chan struct{})
+	fetch := func(context.Context) (*pb.AuthUserResponse, error) {
+		calls.Add(1)
+		<-release
+		return &pb.AuthUserResponse{Id: 1}, nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if user := get(t, c, "a", fetch); user.Id != 1 {
+				t.Errorf("user %d, want 1", user.Id)
+			}
+		}()
+	}
+	for c.Stats().Misses < n {
+		time.Sleep(time.Millisecond)
+	}
+	// Let the last of them reach the shared call.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("%d fetches for %d concurrent Gets, want 1", got, n)
+	}
+	if s := c.Stats(); s.Coalesced != n {
+		t.Errorf("%d coalesced, want %d", s.Coalesced, n)
+	}
+}
+
+func TestGetReturnsCopies(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+	var s userService
+	get(t, c, "a", s.fetch(1)).Role = "admin"
+	if user := get(t, c, "a", s.fetch(1)); user.Role != "" {
+		t.Errorf("cached user changed through a returned copy: role %q", user.Role)
+	}
+}
+
+func TestTTLExpiry(t *testing.T) {
+	c, clock := newTestCache(10, time.Minute)
+	var s userService
+	get(t, c, "a", s.fetch(1))
+	clock.advance(time.Minute - time.Millisecond)
+	get(t, c, "a", s.fetch(1))
+	if got := s.calls.Load(); got != 1 {
+		t.Fatalf("%d fetches within the ttl, want 1", got)
+	}
+	clock.advance(time.Millisecond)
+	get(t, c, "a", s.fetch(1))
+	if got := s.calls.Load(); got != 2 {
+		t.Errorf("%d fetches after the ttl, want 2", got)
+	}
+}
+
+func TestTokenExpiryBeforeTTL(t *testing.T) {
+	c, clock := newTestCache(10, time.Minute)
+	var s userService
+	if _, err := c.Get(context.Background(), "a", clock.now().Add(time.Second), s.fetch(1)); err != nil {
+		t.Fatal(err)
+	}
+	clock.advance(time.Second)
+	get(t, c, "a", s.fetch(1))
+	if got := s.calls.Load(); got != 2 {
+		t.Errorf("%d fetches once the token expired, want 2", got)
+	}
+}
+
+func TestLRUEviction(t *testing.T) {
+	c, _ := newTestCache(2, time.Minute)
+	var s userService
+	get(t, c, "a", s.fetch(1))
+	get(t, c, "b", s.fetch(2))
+	get(t, c, "a", s.fetch(1))
+	// b is the least recently used, so it makes room for c.
+	get(t, c, "c", s.fetch(3))
+	if st := c.Stats(); st.Size != 2 || st.Evictions != 1 {
+		t.Errorf("size %d with %d evictions, want 2 and 1", st.Size, st.Evictions)
+	}
+	calls := s.calls.Load()
+	get(t, c, "a", s.fetch(1))
+	if s.calls.Load() != calls {
+		t.Error("recently used entry was evicted")
+	}
+	get(t, c, "b", s.fetch(2))
+	if s.calls.Load() != calls+1 {
+		t.Error("least recently used entry was kept")
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+	var s userService
+	get(t, c, "a", s.fetch(1))
+	get(t, c, "b", s.fetch(1))
+	get(t, c, "c", s.fetch(2))
+	c.Invalidate("a")
+	c.InvalidateUser(2)
+	if st := c.Stats(); st.Size != 1 || st.Invalidations != 2 {
+		t.Errorf("size %d with %d invalidations, want 1 and 2", st.Size, st.Invalidations)
+	}
+	c.InvalidateUser(1)
+	if st := c.Stats(); st.Size != 0 {
+		t.Errorf("size %d after invalidating every user, want 0", st.Size)
+	}
+}
+
+func TestInvalidateDuringFetch(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+	var s userService
+
+	release := blockedFetch(t, c, "a", 1)
+	c.Invalidate("a")
+	release()
+	get(t, c, "a", s.fetch(1))
+	if s.calls.Load() != 1 {
+		t.Error("answer of a fetch started before its key was invalidated was stored")
+	}
+
+	release = blockedFetch(t, c, "b", 2)
+	c.InvalidateUser(2)
+	release()
+	get(t, c, "b", s.fetch(2))
+	if s.calls.Load() != 2 {
+		t.Error("answer of a fetch started before its user was invalidated was stored")
+	}
+}
+
+func TestInvalidateKeepsOtherFetches(t *testing.T) {
+	c, _ := newTestCache(10, time.Minute)
+	var s userService
+
+	releaseA := blockedFetch(t, c, "a", 1)
+	releaseB := blockedFetch(t, c, "b", 2)
+	c.Invalidate("other")
+	c.InvalidateUser(3)
+	releaseA()
+	releaseB()
+	get(t, c, "a", s.fetch(1))
+	get(t, c, "b", s.fetch(2))
+	if got := s.calls.Load(); got != 0 {
+		t.Errorf("%d fetches after invalidating other keys and users, want the answers in flight kept", got)
+	}
+	if len(c.invalidKeys) != 0 || len(c.invalidUsers) != 0 {
+		t.Errorf("%d keys and %d users still recorded with nothing in flight", len(c.invalidKeys), len(c.invalidUsers))
+	}
+}
@@ -0,0 +1,118 @@
+// Package authctx carries the authenticated caller of a request in its
+// context. Handlers and middleware read it through FromContext instead of
+// asserting raw context values, so a route mounted without authentication
+// fails with an error rather than a panic.
+package authctx
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	pb "github.com/InstaUpload/common/api"
+	"google.golang.org/protobuf/proto"
+)
+
+// ErrNoPrincipal is returned for requests that were not authenticated.
+var ErrNoPrincipal = errors.New("authctx: request is not authenticated")
+
+// Method is how the caller's token was checked.
+type Method string
+
+const (
+	// MethodUserService tokens were checked by the AuthUser call, or taken
+	// from its cache.
+	MethodUserService Method = "user_service"
+	// MethodJWT tokens were verified locally against the JWKS.
+	MethodJWT Method = "jwt"
+	// MethodSession tokens are gateway access tokens of a session.
+	MethodSession Method = "session"
+)
+
+// Token describes the credential a request was authenticated with.
+type Token struct {
+	// Hash is the key the token is revoked and cached under.
+	Hash string
+	// IssuedAt is zero when the gateway can not tell.
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// FamilyID is the session of a gateway access token, empty otherwise.
+	FamilyID string
+}
+
+// Principal is the authenticated caller.
+type Principal struct {
+	UserID   int64
+	Name     string
+	Email    string
+	Roles    []string
+	Verified bool
+	Token    Token
+	Method   Method
+	// Claims are the claims of the caller's JWT as strings, when the
+	// gateway verified it; nil otherwise.
+	Claims map[string][]string
+
+	user *pb.AuthUserResponse
+}
+
+// New builds the principal of user.
+func New(user *pb.AuthUserResponse, token Token, method Method) *Principal {
+	p := &Principal{
+		UserID:   user.Id,
+		Name:     user.Name,
+		Email:    user.Email,
+		Verified: user.IsVerified,
+		Token:    token,
+		Method:   method,
+		user:     user,
+	}
+	if user.Role != "" {
+		p.Roles = []string{user.Role}
+	}
+	return p
+}
+
+// Role returns the primary role of the principal, or "" if it has none.
+func (p *Principal) Role() string {
+	if len(p.Roles) == 0 {
+		return ""
+	}
+	return p.Roles[0]
+}
+
+// HasRole reports whether the principal has any of roles. Role names are
+// compared case-insensitively.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, have := range p.Roles {
+		for _, want := range roles {
+			if strings.EqualFold(have, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// User returns a copy of the user as the user service described it, to be
+// passed on in gRPC requests that act on behalf of the caller.
+func (p *Principal) User() *pb.AuthUserResponse {
+	return proto.Clone(p.user).(*pb.AuthUserResponse)
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying p.
+func NewContext(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of ctx or ErrNoPrincipal.
+func FromContext(ctx context.Context) (*Principal, error) {
+	p, ok := ctx.Value(contextKey{}).(*Principal)
+	if !ok || p == nil {
+		return nil, ErrNoPrincipal
+	}
+	return p, nil
+}
@@ -0,0 +1,55 @@
+// Package authz decides what the roles of the user service may do at the
+// gateway, so requests that are bound to be refused are stopped before
+// they cost a gRPC call. The user service stays the final authority.
+package authz
+
+import "strings"
+
+// Permission names an action guarded at the gateway.
+type Permission string
+
+const (
+	PermUpdateRole     Permission = "users:update_role"
+	PermInviteEditor   Permission = "editors:invite"
+	PermManageLockouts Permission = "lockouts:manage"
+)
+
+// Wildcard grants every permission.
+const Wildcard Permission = "*"
+
+const RoleAdmin = "admin"
+
+// Roles maps a role to the permissions it is granted.
+type Roles map[string][]Permission
+
+// DefaultRoles grants admins every permission and other roles none.
+// Routes that only act on the caller's own account need no permission.
+var DefaultRoles = Roles{
+	RoleAdmin: {Wildcard},
+}
+
+// Allows reports whether any of roles is granted p. Role names are
+// compared case-insensitively.
+func (r Roles) Allows(roles []string, p Permission) bool {
+	for name, perms := range r {
+		if !HasRole(name, roles...) {
+			continue
+		}
+		for _, granted := range perms {
+			if granted == p || granted == Wildcard {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// HasRole reports whether role is one of roles.
+func HasRole(role string, roles ...string) bool {
+	for _, r := range roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
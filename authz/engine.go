@@ -0,0 +1,209 @@
+package authz
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/InstaUpload/gateway/authctx"
+)
+
+// Input is what a policy is evaluated against.
+type Input struct {
+	Method string
+	Route  string
+	Path   string
+	Params map[string]string
+	Query  url.Values
+	Header http.Header
+	// Body holds the top level fields of a JSON request body, only read
+	// when a rule refers to them.
+	Body      map[string]string
+	Principal *authctx.Principal
+}
+
+// Decision is the outcome of evaluating a policy. Rule is empty when no
+// rule matched and the default applied.
+type Decision struct {
+	Effect Effect
+	Rule   string
+}
+
+func (d Decision) Allowed() bool { return d.Effect == Allow }
+
+// Evaluate returns the decision of the first rule matching in.
+func (p *Policy) Evaluate(in Input) Decision {
+	for _, r := range p.Rules {
+		if r.matches(in) {
+			return Decision{Effect: r.Effect, Rule: r.Name}
+		}
+	}
+	return Decision{Effect: p.Default}
+}
+
+func (r Rule) matches(in Input) bool {
+	if len(r.Routes) > 0 && !slices.Contains(r.Routes, in.Route) {
+		return false
+	}
+	if len(r.Methods) > 0 && !slices.Contains(r.Methods, in.Method) {
+		return false
+	}
+	if len(r.Roles) > 0 && (in.Principal == nil || !in.Principal.HasRole(r.Roles...)) {
+		return false
+	}
+	for _, c := range r.When {
+		if !c.holds(in) {
+			return false
+		}
+	}
+	return true
+}
+
+// holds reports whether the condition is true of in. An attribute with
+// several values, such as a list claim, equals a value when any of its
+// values does.
+func (c Condition) holds(in Input) bool {
+	vs, ok := in.values(c.Attr)
+	switch c.Op {
+	case OpExists:
+		return ok
+	case OpMissing:
+		return !ok
+	}
+	values := c.Value
+	if c.ValueFrom != "" {
+		other, found := in.values(c.ValueFrom)
+		if !found {
+			// Comparing with something that is not there matches nothing,
+			// whatever the operator.
+			return false
+		}
+		values = other
+	}
+	equal := slices.ContainsFunc(vs, func(v string) bool { return slices.Contains(values, v) })
+	switch c.Op {
+	case OpEq, OpIn:
+		return ok && equal
+	case OpNe, OpNotIn:
+		return !ok || !equal
+	}
+	return false
+}
+
+// values returns the values of the named attribute and whether it is
+// present. Only claims can have more than one.
+func (in Input) values(name string) ([]string, bool) {
+	if claim, ok := strings.CutPrefix(name, "claim."); ok {
+		if in.Principal == nil {
+			return nil, false
+		}
+		vs, ok := in.Principal.Claims[claim]
+		return vs, ok
+	}
+	v, ok := in.attr(name)
+	return []string{v}, ok
+}
+
+// attr returns the value of the named attribute and whether it is present.
+func (in Input) attr(name string) (string, bool) {
+	switch name {
+	case "method":
+		return in.Method, true
+	case "route":
+		return in.Route, true
+	case "path":
+		return in.Path, true
+	}
+	if user, ok := strings.CutPrefix(name, "user."); ok {
+		p := in.Principal
+		if p == nil {
+			return "", false
+		}
+		switch user {
+		case "id":
+			return strconv.FormatInt(p.UserID, 10), true
+		case "role":
+			return p.Role(), p.Role() != ""
+		case "email":
+			return p.Email, true
+		case "verified":
+			return strconv.FormatBool(p.Verified), true
+		}
+		return "", false
+	}
+	prefix, key, _ := strings.Cut(name, ".")
+	var v string
+	var ok bool
+	switch prefix {
+	case "param":
+		v, ok = in.Params[key]
+	case "query":
+		ok = in.Query.Has(key)
+		v = in.Query.Get(key)
+	case "header":
+		ok = len(in.Header.Values(key)) > 0
+		v = in.Header.Get(key)
+	case "body":
+		v, ok = in.Body[key]
+	}
+	return v, ok
+}
+
+// Engine holds the current policy and swaps it when the policy file changes.
+type Engine struct {
+	policy atomic.Pointer[Policy]
+}
+
+func NewEngine(p *Policy) *Engine {
+	e := &Engine{}
+	e.policy.Store(p)
+	return e
+}
+
+// Policy returns the policy in effect.
+func (e *Engine) Policy() *Policy {
+	return e.policy.Load()
+}
+
+// Watch reloads the policy file whenever its modification time or size changes,
+// checking every interval until ctx is done. A file that fails to load or
+// validate is logged and the previous policy stays in effect.
+func (e *Engine) Watch(ctx context.Context, path string, interval time.Duration) {
+	var modTime time.Time
+	var size int64
+	if fi, err := os.Stat(path); err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			slog.Error("error checking policy file", "file", path, "err", err)
+			continue
+		}
+		if fi.ModTime().Equal(modTime) && fi.Size() == size {
+			continue
+		}
+		modTime, size = fi.ModTime(), fi.Size()
+		p, err := LoadFile(path)
+		if err != nil {
+			slog.Error("error reloading policy, keeping previous policy", "file", path, "err", err)
+			continue
+		}
+		e.policy.Store(p)
+		slog.Info("reloaded policy", "file", path, "rules", len(p.Rules), "mode", p.Mode)
+	}
+}
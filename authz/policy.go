@@ -0,0 +1,245 @@
+package authz
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Mode says whether policy decisions are enforced or only logged.
+type Mode string
+
+const (
+	ModeEnforce Mode = "enforce"
+	// ModeAudit logs what the rules would have denied and lets those
+	// requests through, to try out rules before enforcing them. Permissions
+	// checked by RequirePermission are enforced either way.
+	ModeAudit Mode = "audit"
+)
+
+type Effect string
+
+const (
+	Allow Effect = "allow"
+	Deny  Effect = "deny"
+)
+
+// Policy is the content of a policy file. Rules are evaluated in order and
+// the first one that matches decides; Default applies when none does.
+type Policy struct {
+	Mode    Mode   `yaml:"mode" toml:"mode"`
+	Default Effect `yaml:"default" toml:"default"`
+	// Roles replaces DefaultRoles for RequirePermission when set.
+	Roles Roles  `yaml:"roles" toml:"roles"`
+	Rules []Rule `yaml:"rules" toml:"rules"`
+}
+
+// Rule matches requests by route, method and the role of the current user,
+// then by every condition in When.
+type Rule struct {
+	Name string `yaml:"name" toml:"name"`
+	// Routes are chi route patterns such as /v1/users/send-editor-invite/{u}.
+	// An empty list matches every route, as do empty Methods and Roles.
+	Routes  []string    `yaml:"routes" toml:"routes"`
+	Methods []string    `yaml:"methods" toml:"methods"`
+	Roles   []string    `yaml:"roles" toml:"roles"`
+	When    []Condition `yaml:"when" toml:"when"`
+	Effect  Effect      `yaml:"effect" toml:"effect"`
+}
+
+// Condition compares an attribute of the request with a literal Value or
+// with another attribute named by ValueFrom.
+type Condition struct {
+	Attr      string `yaml:"attr" toml:"attr"`
+	Op        Op     `yaml:"op" toml:"op"`
+	Value     Values `yaml:"value" toml:"value"`
+	ValueFrom string `yaml:"value_from" toml:"value_from"`
+}
+
+// Values accepts a single scalar or a list of them.
+type Values []string
+
+func (v *Values) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*v = Values{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*v = list
+	return nil
+}
+
+func (v *Values) UnmarshalTOML(data any) error {
+	switch d := data.(type) {
+	case []any:
+		*v = make(Values, 0, len(d))
+		for _, item := range d {
+			*v = append(*v, fmt.Sprint(item))
+		}
+	default:
+		*v = Values{fmt.Sprint(d)}
+	}
+	return nil
+}
+
+type Op string
+
+const (
+	OpEq      Op = "eq"
+	OpNe      Op = "ne"
+	OpIn      Op = "in"
+	OpNotIn   Op = "not_in"
+	OpExists  Op = "exists"
+	OpMissing Op = "missing"
+)
+
+var ops = []Op{OpEq, OpNe, OpIn, OpNotIn, OpExists, OpMissing}
+
+// DefaultPolicy enforces DefaultRoles and allows everything else.
+func DefaultPolicy() *Policy {
+	return &Policy{Mode: ModeEnforce, Default: Allow, Roles: DefaultRoles}
+}
+
+// LoadFile reads a YAML or TOML policy file and validates it.
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading policy file: %w", err)
+	}
+	p := &Policy{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(p); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), p)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("parsing %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return nil, fmt.Errorf("policy file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if p.Mode == "" {
+		p.Mode = ModeEnforce
+	}
+	if p.Default == "" {
+		p.Default = Allow
+	}
+	if p.Roles == nil {
+		p.Roles = DefaultRoles
+	}
+	for i := range p.Rules {
+		for j, m := range p.Rules[i].Methods {
+			p.Rules[i].Methods[j] = strings.ToUpper(m)
+		}
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate reports every problem of the policy at once.
+func (p *Policy) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+	if p.Mode != ModeEnforce && p.Mode != ModeAudit {
+		add("mode", "must be %s or %s, got %q", ModeEnforce, ModeAudit, p.Mode)
+	}
+	if p.Default != Allow && p.Default != Deny {
+		add("default", "must be %s or %s, got %q", Allow, Deny, p.Default)
+	}
+	for i, r := range p.Rules {
+		field := fmt.Sprintf("rules[%d]", i)
+		if r.Name == "" {
+			add(field+".name", "must not be empty")
+		}
+		if r.Effect != Allow && r.Effect != Deny {
+			add(field+".effect", "must be %s or %s, got %q", Allow, Deny, r.Effect)
+		}
+		for _, route := range r.Routes {
+			if !strings.HasPrefix(route, "/") {
+				add(field+".routes", "%q must start with /", route)
+			}
+		}
+		for j, c := range r.When {
+			cfield := fmt.Sprintf("%s.when[%d]", field, j)
+			if err := validAttr(c.Attr); err != nil {
+				add(cfield+".attr", "%v", err)
+			}
+			if !slices.Contains(ops, c.Op) {
+				add(cfield+".op", "unknown operator %q", c.Op)
+				continue
+			}
+			switch c.Op {
+			case OpExists, OpMissing:
+				if len(c.Value) > 0 || c.ValueFrom != "" {
+					add(cfield, "%s takes no value", c.Op)
+				}
+			default:
+				if (len(c.Value) > 0) == (c.ValueFrom != "") {
+					add(cfield, "exactly one of value and value_from must be set")
+				}
+				if c.ValueFrom != "" {
+					if err := validAttr(c.ValueFrom); err != nil {
+						add(cfield+".value_from", "%v", err)
+					}
+				}
+				if (c.Op == OpEq || c.Op == OpNe) && len(c.Value) > 1 {
+					add(cfield+".value", "%s takes a single value, use in or not_in", c.Op)
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// attrPrefixes are the attribute namespaces rules can refer to; the part
+// after the prefix names a path parameter, query parameter, header, top
+// level field of a JSON request body or claim of the caller's JWT.
+var attrPrefixes = []string{"param.", "query.", "header.", "body.", "claim."}
+
+var attrNames = []string{"method", "route", "path", "user.id", "user.role", "user.email", "user.verified"}
+
+func validAttr(name string) error {
+	if slices.Contains(attrNames, name) {
+		return nil
+	}
+	for _, prefix := range attrPrefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown attribute %q", name)
+}
+
+// UsesBody reports whether any rule reads the request body.
+func (p *Policy) UsesBody() bool {
+	for _, r := range p.Rules {
+		for _, c := range r.When {
+			if strings.HasPrefix(c.Attr, "body.") || strings.HasPrefix(c.ValueFrom, "body.") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,912 @@
+// Package config loads the gateway configuration from defaults, an optional
+// YAML or TOML file, environment variables and command line flags, in that
+// order of precedence, and validates the result before the server starts.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+	Services  ServicesConfig  `yaml:"services" toml:"services"`
+	GRPC      GRPCConfig      `yaml:"grpc" toml:"grpc"`
+	Swagger   SwaggerConfig   `yaml:"swagger" toml:"swagger"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+	Tracing   TracingConfig   `yaml:"tracing" toml:"tracing"`
+	Admin     AdminConfig     `yaml:"admin" toml:"admin"`
+	Health    HealthConfig    `yaml:"health" toml:"health"`
+}
+
+// HealthConfig configures the readiness checks of the backends.
+type HealthConfig struct {
+	// StartupGrace is how long after startup backends that can not be
+	// reached are reported as starting rather than unavailable.
+	StartupGrace time.Duration `yaml:"startup_grace" toml:"startup_grace"`
+	// Timeout bounds each gRPC health check.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// MaxAge is how long a readiness report is served before the backends
+	// are checked again; zero checks them on every request.
+	MaxAge time.Duration `yaml:"max_age" toml:"max_age"`
+}
+
+// AdminConfig configures the listener for operators, which serves the
+// metrics, pprof and debug endpoints. It must not be reachable by clients.
+type AdminConfig struct {
+	// Addr is a host:port or unix:/path/to/socket listen address; empty
+	// turns the listener off.
+	Addr string `yaml:"addr" toml:"addr"`
+}
+
+// Listen returns the network and address to listen on.
+func (c *AdminConfig) Listen() (network, addr string) {
+	if path, ok := strings.CutPrefix(c.Addr, "unix:"); ok {
+		return "unix", path
+	}
+	return "tcp", c.Addr
+}
+
+// LogConfig configures the structured logger.
+type LogConfig struct {
+	// Format is text or json.
+	Format string `yaml:"format" toml:"format"`
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level" toml:"level"`
+	// Components sets the levels of components, one of LogComponents,
+	// that log at a level other than Level.
+	Components map[string]string `yaml:"components" toml:"components"`
+}
+
+// LogComponents are the components whose levels can be set on their own.
+var LogComponents = []string{"http", "grpc-client", "auth", "ratelimit"}
+
+// SlogLevel returns Level, which Validate has checked.
+func (c *LogConfig) SlogLevel() slog.Level {
+	var l slog.Level
+	_ = l.UnmarshalText([]byte(c.Level))
+	return l
+}
+
+// ComponentLevels returns Components, which Validate has checked.
+func (c *LogConfig) ComponentLevels() map[string]slog.Level {
+	levels := make(map[string]slog.Level, len(c.Components))
+	for component, level := range c.Components {
+		var l slog.Level
+		_ = l.UnmarshalText([]byte(level))
+		levels[component] = l
+	}
+	return levels
+}
+
+// TracingConfig configures where spans of HTTP requests and gRPC calls
+// are exported to.
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp.
+	Exporter string `yaml:"exporter" toml:"exporter"`
+	// Endpoint is the host:port of the OTLP gRPC collector.
+	Endpoint string `yaml:"endpoint" toml:"endpoint"`
+	// Insecure sends spans to Endpoint without TLS.
+	Insecure bool `yaml:"insecure" toml:"insecure"`
+	// SampleRatio is the share of traces that are recorded, whether they
+	// start at the gateway or at the caller. The caller's sampling
+	// decision is ignored.
+	SampleRatio float64 `yaml:"sample_ratio" toml:"sample_ratio"`
+	ServiceName string  `yaml:"service_name" toml:"service_name"`
+}
+
+// Enabled reports whether spans are exported at all.
+func (c *TracingConfig) Enabled() bool {
+	return c.Exporter != "none"
+}
+
+// HTTPConfig configures the public http listener.
+type HTTPConfig struct {
+	Addr           string        `yaml:"addr" toml:"addr"`
+	ReadTimeout    time.Duration `yaml:"read_timeout" toml:"read_timeout"`
+	WriteTimeout   time.Duration `yaml:"write_timeout" toml:"write_timeout"`
+	IdleTimeout    time.Duration `yaml:"idle_timeout" toml:"idle_timeout"`
+	HandlerTimeout time.Duration `yaml:"handler_timeout" toml:"handler_timeout"`
+	// DrainDelay is how long the gateway keeps serving after a shutdown
+	// signal while reporting itself not ready, giving load balancers time
+	// to stop sending it requests.
+	DrainDelay time.Duration `yaml:"drain_delay" toml:"drain_delay"`
+	// ShutdownTimeout bounds how long requests still running after the
+	// drain delay may take to finish before they are cut off.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+}
+
+// ServicesConfig holds the addresses of the backend microservices.
+type ServicesConfig struct {
+	User ServiceConfig `yaml:"user" toml:"user"`
+}
+
+type ServiceConfig struct {
+	Addr string `yaml:"addr" toml:"addr"`
+	// HealthService is the service name asked for in gRPC health checks;
+	// empty asks for the server as a whole.
+	HealthService string `yaml:"health_service" toml:"health_service"`
+}
+
+// GRPCConfig holds the deadlines applied to outgoing gRPC calls. Deadlines
+// is keyed by chi route pattern, e.g. "/v1/users/login", and falls back to
+// DefaultDeadline for routes without an entry.
+type GRPCConfig struct {
+	DefaultDeadline time.Duration            `yaml:"default_deadline" toml:"default_deadline"`
+	Deadlines       map[string]time.Duration `yaml:"deadlines" toml:"deadlines"`
+	Retry           RetryConfig              `yaml:"retry" toml:"retry"`
+	Keepalive       KeepaliveConfig          `yaml:"keepalive" toml:"keepalive"`
+}
+
+// RetryConfig sets how failed calls to the user service are retried.
+// Methods is keyed by method name, e.g. "AuthUser"; methods without an
+// entry are not retried.
+type RetryConfig struct {
+	Backoff BackoffConfig                `yaml:"backoff" toml:"backoff"`
+	Budget  RetryBudgetConfig            `yaml:"budget" toml:"budget"`
+	Methods map[string]RetryPolicyConfig `yaml:"methods" toml:"methods"`
+}
+
+// BackoffConfig spaces retries: the wait before retry n is random, up to
+// Initial times Multiplier to the power of n-1 and at most Max.
+type BackoffConfig struct {
+	Initial    time.Duration `yaml:"initial" toml:"initial"`
+	Max        time.Duration `yaml:"max" toml:"max"`
+	Multiplier float64       `yaml:"multiplier" toml:"multiplier"`
+}
+
+// RetryBudgetConfig bounds retries across methods. Every failed attempt
+// takes a token and every successful call puts back TokenRatio; retries
+// stop while half of MaxTokens or fewer are left.
+type RetryBudgetConfig struct {
+	MaxTokens  float64 `yaml:"max_tokens" toml:"max_tokens"`
+	TokenRatio float64 `yaml:"token_ratio" toml:"token_ratio"`
+}
+
+// RetryPolicyConfig is how the calls of a method are retried.
+type RetryPolicyConfig struct {
+	// MaxAttempts counts the first attempt too; 1 turns retries off.
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// Codes are the gRPC status codes retried, e.g. UNAVAILABLE, which is
+	// also the default.
+	Codes []string `yaml:"codes" toml:"codes"`
+	// RequireIdempotencyKey only retries calls made for requests with an
+	// Idempotency-Key header.
+	RequireIdempotencyKey bool `yaml:"require_idempotency_key" toml:"require_idempotency_key"`
+}
+
+// RetryCodes returns Codes, or UNAVAILABLE if there are none.
+func (c RetryPolicyConfig) RetryCodes() []string {
+	if len(c.Codes) == 0 {
+		return []string{"UNAVAILABLE"}
+	}
+	return c.Codes
+}
+
+// UserServiceMethods are the methods of the user service.
+var UserServiceMethods = []string{
+	"CreateUser", "LoginUser", "AuthUser", "UpdateUserRole", "ResetUserPassword",
+	"UpdateUserPassword", "VerifyUser", "SendVerificationUser", "AddEditorUser", "SendEditorUser",
+}
+
+// grpcCodes are the names of the gRPC status codes other than OK.
+var grpcCodes = []string{
+	"CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION",
+	"ABORTED", "OUT_OF_RANGE", "UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS",
+	"UNAUTHENTICATED",
+}
+
+// KeepaliveConfig makes the client ping the user service after Time
+// without activity on the connection, and close the connection if the
+// ping is not answered within Timeout. A Time of zero turns pings off.
+// Servers close connections that ping more often than they allow, five
+// minutes by default in gRPC-Go, unless told otherwise.
+type KeepaliveConfig struct {
+	Time    time.Duration `yaml:"time" toml:"time"`
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	// PermitWithoutStream pings even when no calls are in flight, finding
+	// dead connections before a call is made on them.
+	PermitWithoutStream bool `yaml:"permit_without_stream" toml:"permit_without_stream"`
+}
+
+// SwaggerConfig controls the host advertised in the generated API docs.
+type SwaggerConfig struct {
+	Host   string `yaml:"host" toml:"host"`
+	Scheme string `yaml:"scheme" toml:"scheme"`
+}
+
+// AuthConfig configures how tokens are handed to clients.
+type AuthConfig struct {
+	// AccessTokenTTL is the lifetime of the access tokens the gateway issues
+	// when refresh tokens are enabled.
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl" toml:"access_token_ttl"`
+	// UpstreamTokenTTL is assumed for user service tokens that do not carry
+	// their own expiry.
+	UpstreamTokenTTL time.Duration     `yaml:"upstream_token_ttl" toml:"upstream_token_ttl"`
+	Refresh          RefreshConfig     `yaml:"refresh" toml:"refresh"`
+	Cookie           CookieConfig      `yaml:"cookie" toml:"cookie"`
+	Cache            AuthCacheConfig   `yaml:"cache" toml:"cache"`
+	JWT              JWTConfig         `yaml:"jwt" toml:"jwt"`
+	Policy           PolicyConfig      `yaml:"policy" toml:"policy"`
+	Lockout          LockoutConfig     `yaml:"lockout" toml:"lockout"`
+	Enumeration      EnumerationConfig `yaml:"enumeration" toml:"enumeration"`
+	Password         PasswordConfig    `yaml:"password" toml:"password"`
+	// RevocationMaxEntries bounds the number of logged out tokens, and of
+	// users logged out of all sessions, kept in memory until they expire.
+	RevocationMaxEntries int `yaml:"revocation_max_entries" toml:"revocation_max_entries"`
+}
+
+// RefreshConfig enables gateway issued access and rotating refresh tokens.
+// When disabled clients receive the user service token directly.
+type RefreshConfig struct {
+	Enabled  bool          `yaml:"enabled" toml:"enabled"`
+	TokenTTL time.Duration `yaml:"token_ttl" toml:"token_ttl"`
+}
+
+// AuthCacheConfig bounds the cache of AuthUser responses used by
+// GetCurrentUser. Role changes made outside the gateway show up after at
+// most TTL.
+type AuthCacheConfig struct {
+	Enabled    bool          `yaml:"enabled" toml:"enabled"`
+	TTL        time.Duration `yaml:"ttl" toml:"ttl"`
+	MaxEntries int           `yaml:"max_entries" toml:"max_entries"`
+}
+
+// JWTConfig enables verifying user service JWTs locally against a JWKS
+// document instead of calling AuthUser. Exactly one of JWKSURL and JWKSFile
+// must be set when it is enabled.
+type JWTConfig struct {
+	Enabled         bool          `yaml:"enabled" toml:"enabled"`
+	JWKSURL         string        `yaml:"jwks_url" toml:"jwks_url"`
+	JWKSFile        string        `yaml:"jwks_file" toml:"jwks_file"`
+	RefreshInterval time.Duration `yaml:"refresh_interval" toml:"refresh_interval"`
+	// Issuer and Audience are checked when set.
+	Issuer     string   `yaml:"issuer" toml:"issuer"`
+	Audience   string   `yaml:"audience" toml:"audience"`
+	Algorithms []string `yaml:"algorithms" toml:"algorithms"`
+	// Leeway allows for clock skew when checking exp, nbf and iat.
+	Leeway time.Duration `yaml:"leeway" toml:"leeway"`
+	Claims JWTClaims     `yaml:"claims" toml:"claims"`
+}
+
+// JWTClaims names the claims the user is read from.
+type JWTClaims struct {
+	UserID   string `yaml:"user_id" toml:"user_id"`
+	Name     string `yaml:"name" toml:"name"`
+	Email    string `yaml:"email" toml:"email"`
+	Role     string `yaml:"role" toml:"role"`
+	Verified string `yaml:"verified" toml:"verified"`
+}
+
+// JWTAlgorithms lists the signing algorithms the gateway can verify.
+var JWTAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+// PolicyConfig points at the authorization policy file. Without a file the
+// built in policy, which only grants admins their permissions, applies.
+type PolicyConfig struct {
+	File string `yaml:"file" toml:"file"`
+	// ReloadInterval is how often the file is checked for changes.
+	ReloadInterval time.Duration `yaml:"reload_interval" toml:"reload_interval"`
+}
+
+// LockoutConfig slows down and then locks out repeated failed logins,
+// counted separately per email and per client address.
+type LockoutConfig struct {
+	Enabled bool          `yaml:"enabled" toml:"enabled"`
+	Email   LockoutPolicy `yaml:"email" toml:"email"`
+	IP      LockoutPolicy `yaml:"ip" toml:"ip"`
+	// MaxEntries bounds the emails and addresses tracked in memory.
+	MaxEntries int `yaml:"max_entries" toml:"max_entries"`
+}
+
+// LockoutPolicy makes a key wait BaseDelay after a failed login, doubling
+// per failure up to MaxDelay, and locks it for LockDuration once Threshold
+// failures are reached, doubling per lock up to MaxLockDuration. Failures
+// older than Window are forgotten.
+type LockoutPolicy struct {
+	Threshold       int           `yaml:"threshold" toml:"threshold"`
+	BaseDelay       time.Duration `yaml:"base_delay" toml:"base_delay"`
+	MaxDelay        time.Duration `yaml:"max_delay" toml:"max_delay"`
+	LockDuration    time.Duration `yaml:"lock_duration" toml:"lock_duration"`
+	MaxLockDuration time.Duration `yaml:"max_lock_duration" toml:"max_lock_duration"`
+	Window          time.Duration `yaml:"window" toml:"window"`
+}
+
+// EnumerationConfig makes create, reset-password and verify answer the
+// same whether or not the account exists, and pads their responses to
+// LatencyBudget so their timing does not tell either.
+type EnumerationConfig struct {
+	Enabled       bool          `yaml:"enabled" toml:"enabled"`
+	LatencyBudget time.Duration `yaml:"latency_budget" toml:"latency_budget"`
+	// CreateAccepted answers create with 202 rather than 201, as the
+	// account may not have been created. Off by default as it changes the
+	// API.
+	CreateAccepted bool `yaml:"create_accepted" toml:"create_accepted"`
+}
+
+// PasswordConfig is the policy new passwords of CreateUser and
+// UpdateUserPassword must meet. Zero values switch a rule off.
+type PasswordConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// MinLength counts characters, MaxLength bytes, as bcrypt ignores
+	// anything past 72 bytes.
+	MinLength int `yaml:"min_length" toml:"min_length"`
+	MaxLength int `yaml:"max_length" toml:"max_length"`
+	// MinClasses is how many of lower case, upper case, digits and symbols
+	// must appear.
+	MinClasses  int      `yaml:"min_classes" toml:"min_classes"`
+	MinEntropy  float64  `yaml:"min_entropy_bits" toml:"min_entropy_bits"`
+	BannedWords []string `yaml:"banned_words" toml:"banned_words"`
+	// BreachedDir holds SHA-1 range files of breached passwords, see
+	// password.RangeDir. No breach check is made when it is empty.
+	BreachedDir    string `yaml:"breached_dir" toml:"breached_dir"`
+	MinBreachCount int    `yaml:"min_breach_count" toml:"min_breach_count"`
+}
+
+// RateLimitConfig holds the limits that routes refer to by name in mount().
+// A route whose limit is not listed here is not limited.
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// TrustedProxies lists the addresses or CIDR ranges of proxies whose
+	// X-Forwarded-For hops are believed when telling clients apart.
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	APIKeyHeader   string   `yaml:"api_key_header" toml:"api_key_header"`
+	// APIKeys lists the SHA-256 hex digests of the API keys issued to
+	// clients. Limits keyed by api_key count requests carrying any other
+	// key by ip, so made up keys cannot buy a fresh bucket each.
+	APIKeys []string `yaml:"api_keys" toml:"api_keys"`
+	// MaxKeys bounds the clients tracked in memory.
+	MaxKeys int `yaml:"max_keys" toml:"max_keys"`
+	// Limits replaces a default entry as a whole, so every field of an
+	// entry has to be given.
+	Limits map[string]RateLimit `yaml:"limits" toml:"limits"`
+}
+
+// RateLimit allows Requests per Period to each client, told apart by Key.
+type RateLimit struct {
+	// Algorithm is token_bucket or sliding_window.
+	Algorithm string        `yaml:"algorithm" toml:"algorithm"`
+	Requests  int           `yaml:"requests" toml:"requests"`
+	Period    time.Duration `yaml:"period" toml:"period"`
+	// Burst is the bucket size of a token bucket, Requests when zero.
+	Burst int `yaml:"burst" toml:"burst"`
+	// Key is ip, user or api_key. Requests without a user or a known API
+	// key are counted by ip.
+	Key string `yaml:"key" toml:"key"`
+}
+
+// TrustedPrefixes parses TrustedProxies, which Validate has checked.
+func (c *RateLimitConfig) TrustedPrefixes() []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, p := range c.TrustedProxies {
+		if prefix, err := parsePrefix(p); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		return p.Masked(), err
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// CookieConfig controls the optional Secure, HttpOnly session cookie set on
+// login for browser clients.
+type CookieConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"`
+	Name    string `yaml:"name" toml:"name"`
+	// RefreshName is the cookie holding the refresh token, scoped to the
+	// refresh endpoint.
+	RefreshName string `yaml:"refresh_name" toml:"refresh_name"`
+	Domain      string `yaml:"domain" toml:"domain"`
+	Path        string `yaml:"path" toml:"path"`
+	SameSite    string `yaml:"same_site" toml:"same_site"`
+}
+
+// Default returns the configuration used when nothing else is provided.
+func Default() *Config {
+	return &Config{
+		HTTP: HTTPConfig{
+			Addr:            ":5000",
+			ReadTimeout:     40 * time.Second,
+			WriteTimeout:    30 * time.Second,
+			IdleTimeout:     time.Minute,
+			HandlerTimeout:  60 * time.Second,
+			DrainDelay:      5 * time.Second,
+			ShutdownTimeout: 30 * time.Second,
+		},
+		Services: ServicesConfig{
+			User: ServiceConfig{Addr: "localhost:5003"},
+		},
+		GRPC: GRPCConfig{
+			DefaultDeadline: 5 * time.Second,
+			Deadlines:       map[string]time.Duration{},
+			Retry: RetryConfig{
+				Backoff: BackoffConfig{Initial: 50 * time.Millisecond, Max: time.Second, Multiplier: 2},
+				Budget:  RetryBudgetConfig{MaxTokens: 10, TokenRatio: 0.1},
+				Methods: map[string]RetryPolicyConfig{
+					"AuthUser":   {MaxAttempts: 3},
+					"VerifyUser": {MaxAttempts: 3},
+					"CreateUser": {MaxAttempts: 3, RequireIdempotencyKey: true},
+				},
+			},
+			Keepalive: KeepaliveConfig{Time: 5 * time.Minute, Timeout: 20 * time.Second},
+		},
+		Swagger: SwaggerConfig{
+			Host:   "localhost:5000",
+			Scheme: "http",
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:   15 * time.Minute,
+			UpstreamTokenTTL: 24 * time.Hour,
+			Refresh: RefreshConfig{
+				Enabled:  true,
+				TokenTTL: 30 * 24 * time.Hour,
+			},
+			Cookie: CookieConfig{
+				Name:        "access_token",
+				RefreshName: "refresh_token",
+				Path:        "/",
+				SameSite:    "strict",
+			},
+			Cache: AuthCacheConfig{
+				Enabled:    true,
+				TTL:        30 * time.Second,
+				MaxEntries: 10000,
+			},
+			JWT: JWTConfig{
+				RefreshInterval: 15 * time.Minute,
+				Algorithms:      []string{"RS256", "ES256", "EdDSA"},
+				Leeway:          30 * time.Second,
+				Claims: JWTClaims{
+					UserID:   "sub",
+					Name:     "name",
+					Email:    "email",
+					Role:     "role",
+					Verified: "is_verified",
+				},
+			},
+			Policy: PolicyConfig{
+				ReloadInterval: 10 * time.Second,
+			},
+			Lockout: LockoutConfig{
+				Enabled: true,
+				Email: LockoutPolicy{
+					Threshold:       5,
+					BaseDelay:       time.Second,
+					MaxDelay:        30 * time.Second,
+					LockDuration:    15 * time.Minute,
+					MaxLockDuration: 24 * time.Hour,
+					Window:          15 * time.Minute,
+				},
+				IP: LockoutPolicy{
+					Threshold:       50,
+					LockDuration:    15 * time.Minute,
+					MaxLockDuration: 24 * time.Hour,
+					Window:          15 * time.Minute,
+				},
+				MaxEntries: 100000,
+			},
+			Enumeration: EnumerationConfig{
+				Enabled:       true,
+				LatencyBudget: time.Second,
+			},
+			Password: PasswordConfig{
+				Enabled:        true,
+				MinLength:      10,
+				MaxLength:      72,
+				MinClasses:     2,
+				MinEntropy:     40,
+				BannedWords:    []string{"password", "qwerty", "letmein", "welcome", "iloveyou", "instaupload"},
+				MinBreachCount: 1,
+			},
+			RevocationMaxEntries: 100000,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Health: HealthConfig{
+			StartupGrace: 30 * time.Second,
+			Timeout:      2 * time.Second,
+			MaxAge:       time.Second,
+		},
+		Admin: AdminConfig{
+			Addr: "localhost:9090",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			Endpoint:    "localhost:4317",
+			SampleRatio: 1,
+			ServiceName: "gateway",
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:      true,
+			APIKeyHeader: "X-API-Key",
+			MaxKeys:      100000,
+			Limits: map[string]RateLimit{
+				"login":           {Algorithm: "sliding_window", Requests: 10, Period: time.Minute, Key: "ip"},
+				"create":          {Algorithm: "sliding_window", Requests: 10, Period: time.Hour, Key: "ip"},
+				"reset_password":  {Algorithm: "sliding_window", Requests: 5, Period: 15 * time.Minute, Key: "ip"},
+				"update_password": {Algorithm: "sliding_window", Requests: 10, Period: 15 * time.Minute, Key: "ip"},
+				"verify":          {Algorithm: "token_bucket", Requests: 30, Period: time.Minute, Burst: 10, Key: "ip"},
+				"refresh":         {Algorithm: "token_bucket", Requests: 30, Period: time.Minute, Burst: 10, Key: "ip"},
+				"authenticated":   {Algorithm: "token_bucket", Requests: 120, Period: time.Minute, Burst: 30, Key: "user"},
+			},
+		},
+	}
+}
+
+// Deadline returns the gRPC deadline for the given chi route pattern.
+func (c *GRPCConfig) Deadline(route string) time.Duration {
+	if d, ok := c.Deadlines[route]; ok {
+		return d
+	}
+	return c.DefaultDeadline
+}
+
+// Validate reports every invalid value at once so a misconfigured
+// deployment can be fixed in a single pass.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(field, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{field}, args...)...))
+	}
+
+	if err := validateAddr(c.HTTP.Addr); err != nil {
+		add("http.addr", "%v", err)
+	}
+	positive := map[string]time.Duration{
+		"http.read_timeout":       c.HTTP.ReadTimeout,
+		"http.write_timeout":      c.HTTP.WriteTimeout,
+		"http.idle_timeout":       c.HTTP.IdleTimeout,
+		"http.handler_timeout":    c.HTTP.HandlerTimeout,
+		"http.shutdown_timeout":   c.HTTP.ShutdownTimeout,
+		"grpc.default_deadline":   c.GRPC.DefaultDeadline,
+		"auth.access_token_ttl":   c.Auth.AccessTokenTTL,
+		"auth.upstream_token_ttl": c.Auth.UpstreamTokenTTL,
+	}
+	for _, field := range sortedKeys(positive) {
+		if positive[field] <= 0 {
+			add(field, "must be greater than zero, got %s", positive[field])
+		}
+	}
+	if c.HTTP.HandlerTimeout > 0 && c.GRPC.DefaultDeadline > c.HTTP.HandlerTimeout {
+		add("grpc.default_deadline", "%s exceeds http.handler_timeout %s", c.GRPC.DefaultDeadline, c.HTTP.HandlerTimeout)
+	}
+	for _, route := range sortedKeys(c.GRPC.Deadlines) {
+		d := c.GRPC.Deadlines[route]
+		field := "grpc.deadlines[" + route + "]"
+		switch {
+		case !strings.HasPrefix(route, "/"):
+			add(field, "route pattern must start with /")
+		case d <= 0:
+			add(field, "must be greater than zero, got %s", d)
+		case c.HTTP.HandlerTimeout > 0 && d > c.HTTP.HandlerTimeout:
+			add(field, "%s exceeds http.handler_timeout %s", d, c.HTTP.HandlerTimeout)
+		}
+	}
+	if b := c.GRPC.Retry.Backoff; b.Initial <= 0 {
+		add("grpc.retry.backoff.initial", "must be greater than zero, got %s", b.Initial)
+	} else if b.Max < b.Initial {
+		add("grpc.retry.backoff.max", "%s is less than grpc.retry.backoff.initial %s", b.Max, b.Initial)
+	}
+	if m := c.GRPC.Retry.Backoff.Multiplier; m < 1 {
+		add("grpc.retry.backoff.multiplier", "must be at least 1, got %g", m)
+	}
+	if n := c.GRPC.Retry.Budget.MaxTokens; n <= 0 {
+		add("grpc.retry.budget.max_tokens", "must be greater than zero, got %g", n)
+	}
+	if n := c.GRPC.Retry.Budget.TokenRatio; n <= 0 {
+		add("grpc.retry.budget.token_ratio", "must be greater than zero, got %g", n)
+	}
+	for _, method := range sortedKeys(c.GRPC.Retry.Methods) {
+		p := c.GRPC.Retry.Methods[method]
+		field := "grpc.retry.methods." + method
+		if !slices.Contains(UserServiceMethods, method) {
+			add(field, "unknown user service method")
+			continue
+		}
+		if p.MaxAttempts < 1 || p.MaxAttempts > 5 {
+			add(field+".max_attempts", "must be between 1 and 5, got %d", p.MaxAttempts)
+		}
+		for _, code := range p.Codes {
+			if !slices.Contains(grpcCodes, code) {
+				add(field+".codes", "unknown status code %q, use names such as UNAVAILABLE", code)
+			}
+		}
+		// Repeating a sign up the user service already handled would
+		// create the account twice or fail it as taken.
+		if method == "CreateUser" && p.MaxAttempts > 1 && !p.RequireIdempotencyKey {
+			add(field+".require_idempotency_key", "must be true to retry CreateUser")
+		}
+	}
+	if k := c.GRPC.Keepalive; k.Time != 0 {
+		if k.Time < 10*time.Second {
+			add("grpc.keepalive.time", "must be 0 or at least 10s, got %s", k.Time)
+		}
+		if k.Timeout <= 0 {
+			add("grpc.keepalive.timeout", "must be greater than zero, got %s", k.Timeout)
+		}
+	}
+	if err := validateAddr(c.Services.User.Addr); err != nil {
+		add("services.user.addr", "%v", err)
+	}
+	if c.Swagger.Host == "" {
+		add("swagger.host", "must not be empty")
+	}
+	if c.Swagger.Scheme != "http" && c.Swagger.Scheme != "https" {
+		add("swagger.scheme", "must be http or https, got %q", c.Swagger.Scheme)
+	}
+	if c.Auth.Cache.Enabled {
+		if c.Auth.Cache.TTL <= 0 {
+			add("auth.cache.ttl", "must be greater than zero, got %s", c.Auth.Cache.TTL)
+		}
+		if c.Auth.Cache.MaxEntries <= 0 {
+			add("auth.cache.max_entries", "must be greater than zero, got %d", c.Auth.Cache.MaxEntries)
+		}
+	}
+	if jwt := c.Auth.JWT; jwt.Enabled {
+		if (jwt.JWKSURL == "") == (jwt.JWKSFile == "") {
+			add("auth.jwt", "exactly one of jwks_url and jwks_file must be set")
+		}
+		if jwt.JWKSURL != "" {
+			if u, err := url.Parse(jwt.JWKSURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				add("auth.jwt.jwks_url", "must be an http or https URL, got %q", jwt.JWKSURL)
+			}
+		}
+		if jwt.RefreshInterval <= 0 {
+			add("auth.jwt.refresh_interval", "must be greater than zero, got %s", jwt.RefreshInterval)
+		}
+		if jwt.Leeway < 0 {
+			add("auth.jwt.leeway", "must not be negative, got %s", jwt.Leeway)
+		}
+		if len(jwt.Algorithms) == 0 {
+			add("auth.jwt.algorithms", "must list at least one algorithm")
+		}
+		for _, alg := range jwt.Algorithms {
+			if !slices.Contains(JWTAlgorithms, alg) {
+				add("auth.jwt.algorithms", "unsupported algorithm %q, use one of %s", alg, strings.Join(JWTAlgorithms, ", "))
+			}
+		}
+		if jwt.Claims.UserID == "" {
+			add("auth.jwt.claims.user_id", "must not be empty")
+		}
+	}
+	if c.Auth.Policy.File != "" && c.Auth.Policy.ReloadInterval <= 0 {
+		add("auth.policy.reload_interval", "must be greater than zero, got %s", c.Auth.Policy.ReloadInterval)
+	}
+	if c.Auth.Lockout.Enabled {
+		if c.Auth.Lockout.MaxEntries <= 0 {
+			add("auth.lockout.max_entries", "must be greater than zero, got %d", c.Auth.Lockout.MaxEntries)
+		}
+		for _, l := range []struct {
+			field  string
+			policy LockoutPolicy
+		}{{"auth.lockout.email", c.Auth.Lockout.Email}, {"auth.lockout.ip", c.Auth.Lockout.IP}} {
+			field, p := l.field, l.policy
+			if p.Threshold <= 0 {
+				add(field+".threshold", "must be greater than zero, got %d", p.Threshold)
+			}
+			if p.BaseDelay < 0 || p.MaxDelay < p.BaseDelay {
+				add(field+".max_delay", "must be at least base_delay %s and neither negative, got %s", p.BaseDelay, p.MaxDelay)
+			}
+			if p.LockDuration <= 0 || p.MaxLockDuration < p.LockDuration {
+				add(field+".max_lock_duration", "must be at least lock_duration %s and both greater than zero, got %s", p.LockDuration, p.MaxLockDuration)
+			}
+			if p.Window <= 0 {
+				add(field+".window", "must be greater than zero, got %s", p.Window)
+			}
+		}
+	}
+	if c.Auth.Enumeration.Enabled {
+		if c.Auth.Enumeration.LatencyBudget <= 0 {
+			add("auth.enumeration.latency_budget", "must be greater than zero, got %s", c.Auth.Enumeration.LatencyBudget)
+		} else if c.Auth.Enumeration.LatencyBudget >= c.HTTP.HandlerTimeout {
+			add("auth.enumeration.latency_budget", "%s must be shorter than http.handler_timeout %s", c.Auth.Enumeration.LatencyBudget, c.HTTP.HandlerTimeout)
+		}
+	}
+	if pw := c.Auth.Password; pw.Enabled {
+		// Request bodies already require 10 to 72 characters, the default
+		// min_length, so the policy can only be stricter than the API docs.
+		if pw.MinLength < 10 || pw.MinLength > 72 {
+			add("auth.password.min_length", "must be between 10 and 72, got %d", pw.MinLength)
+		}
+		if pw.MaxLength < pw.MinLength || pw.MaxLength > 72 {
+			add("auth.password.max_length", "must be between min_length and 72, got %d", pw.MaxLength)
+		}
+		if pw.MinClasses < 0 || pw.MinClasses > 4 {
+			add("auth.password.min_classes", "must be between 0 and 4, got %d", pw.MinClasses)
+		}
+		if pw.MinEntropy < 0 {
+			add("auth.password.min_entropy_bits", "must not be negative, got %g", pw.MinEntropy)
+		}
+		if pw.MinBreachCount < 1 {
+			add("auth.password.min_breach_count", "must be at least 1, got %d", pw.MinBreachCount)
+		}
+	}
+	if c.Auth.RevocationMaxEntries <= 0 {
+		add("auth.revocation_max_entries", "must be greater than zero, got %d", c.Auth.RevocationMaxEntries)
+	}
+	if c.Auth.Refresh.Enabled {
+		if c.Auth.Refresh.TokenTTL <= c.Auth.AccessTokenTTL {
+			add("auth.refresh.token_ttl", "%s must be longer than auth.access_token_ttl %s", c.Auth.Refresh.TokenTTL, c.Auth.AccessTokenTTL)
+		}
+	}
+	if c.Auth.Cookie.Enabled {
+		if c.Auth.Cookie.Name == "" {
+			add("auth.cookie.name", "must not be empty when the cookie is enabled")
+		}
+		if c.Auth.Refresh.Enabled && (c.Auth.Cookie.RefreshName == "" || c.Auth.Cookie.RefreshName == c.Auth.Cookie.Name) {
+			add("auth.cookie.refresh_name", "must be set and differ from auth.cookie.name")
+		}
+		switch c.Auth.Cookie.SameSite {
+		case "strict", "lax", "none":
+		default:
+			add("auth.cookie.same_site", "must be strict, lax or none, got %q", c.Auth.Cookie.SameSite)
+		}
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("log.format", "must be text or json, got %q", c.Log.Format)
+	}
+	if err := new(slog.Level).UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	for _, component := range sortedKeys(c.Log.Components) {
+		if !slices.Contains(LogComponents, component) {
+			add("log.components", "unknown component %q, use one of %s", component, strings.Join(LogComponents, ", "))
+		}
+		if err := new(slog.Level).UnmarshalText([]byte(c.Log.Components[component])); err != nil {
+			add("log.components."+component, "must be debug, info, warn or error, got %q", c.Log.Components[component])
+		}
+	}
+	if c.HTTP.DrainDelay < 0 {
+		add("http.drain_delay", "must not be negative, got %s", c.HTTP.DrainDelay)
+	}
+	if c.Health.StartupGrace < 0 {
+		add("health.startup_grace", "must not be negative, got %s", c.Health.StartupGrace)
+	}
+	if c.Health.Timeout <= 0 {
+		add("health.timeout", "must be greater than zero, got %s", c.Health.Timeout)
+	}
+	if c.Health.MaxAge < 0 {
+		add("health.max_age", "must not be negative, got %s", c.Health.MaxAge)
+	}
+	if c.Admin.Addr != "" {
+		switch network, addr := c.Admin.Listen(); {
+		case c.Admin.Addr == c.HTTP.Addr:
+			add("admin.addr", "must differ from http.addr, the admin listener must not be public")
+		case network == "unix" && addr == "":
+			add("admin.addr", "must name a socket path after unix:")
+		case network == "tcp":
+			if err := validateAddr(addr); err != nil {
+				add("admin.addr", "%v", err)
+			}
+		}
+	}
+	switch c.Tracing.Exporter {
+	case "none", "stdout":
+	case "otlp":
+		if c.Tracing.Endpoint == "" {
+			add("tracing.endpoint", "must not be empty when the exporter is otlp")
+		}
+	default:
+		add("tracing.exporter", "must be none, stdout or otlp, got %q", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio", "must be between 0 and 1, got %v", c.Tracing.SampleRatio)
+	}
+	if c.Tracing.Enabled() && c.Tracing.ServiceName == "" {
+		add("tracing.service_name", "must not be empty when tracing is enabled")
+	}
+	for _, p := range c.RateLimit.TrustedProxies {
+		if _, err := parsePrefix(p); err != nil {
+			add("rate_limit.trusted_proxies", "%q is not an address or CIDR range", p)
+		}
+	}
+	if c.RateLimit.Enabled {
+		if c.RateLimit.APIKeyHeader == "" {
+			add("rate_limit.api_key_header", "must not be empty")
+		}
+		// The entries are not echoed in case a raw key was put there.
+		for i, k := range c.RateLimit.APIKeys {
+			if len(k) != 64 || strings.Trim(k, "0123456789abcdef") != "" {
+				add("rate_limit.api_keys", "entry %d is not a lowercase SHA-256 hex digest", i)
+			}
+		}
+		if c.RateLimit.MaxKeys <= 0 {
+			add("rate_limit.max_keys", "must be greater than zero, got %d", c.RateLimit.MaxKeys)
+		}
+		for _, name := range sortedKeys(c.RateLimit.Limits) {
+			l := c.RateLimit.Limits[name]
+			field := "rate_limit.limits." + name
+			if l.Algorithm != "token_bucket" && l.Algorithm != "sliding_window" {
+				add(field+".algorithm", "must be token_bucket or sliding_window, got %q", l.Algorithm)
+			}
+			if l.Requests <= 0 {
+				add(field+".requests", "must be greater than zero, got %d", l.Requests)
+			}
+			if l.Period <= 0 {
+				add(field+".period", "must be greater than zero, got %s", l.Period)
+			}
+			if l.Burst < 0 {
+				add(field+".burst", "must not be negative, got %d", l.Burst)
+			}
+			if l.Key != "ip" && l.Key != "user" && l.Key != "api_key" {
+				add(field+".key", "must be ip, user or api_key, got %q", l.Key)
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// Masked returns a copy of c that is safe to show to operators: passwords
+// and query strings of URLs are replaced by Masked. Fields added to hold
+// secrets have to be masked here as well.
+func (c Config) Masked() Config {
+	c.Auth.JWT.JWKSURL = maskURL(c.Auth.JWT.JWKSURL)
+	return c
+}
+
+// Masked replaces secrets in Config.Masked.
+const Masked = "xxxxx"
+
+func maskURL(s string) string {
+	if s == "" {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return Masked
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Masked)
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = Masked
+	}
+	return u.String()
+}
+
+func validateAddr(addr string) error {
+	if addr == "" {
+		return errors.New("must not be empty")
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("%q is not a host:port address", addr)
+	}
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/InstaUpload/gateway/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from defaults, the file named by -config or
+// CONFIG_FILE, the environment and finally args, then validates it.
+func Load(args []string) (*Config, error) {
+	// Parse the flags once against a scratch config to find the config file
+	// and remember which flags were set; they are applied again last so they
+	// win over the file and the environment.
+	fs := newFlagSet(Default())
+	path := fs.String("config", utils.GetEnvString("CONFIG_FILE", ""), "path to a YAML or TOML config file")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if *path != "" {
+		if err := loadFile(*path, cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := loadEnv(cfg); err != nil {
+		return nil, err
+	}
+	final := newFlagSet(cfg)
+	var errs []error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := final.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("parsing %s: unknown keys %v", path, undecoded)
+		}
+	default:
+		return fmt.Errorf("config file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	return nil
+}
+
+func loadEnv(cfg *Config) error {
+	var errs []error
+	duration := func(key string, dst *time.Duration) {
+		d, err := utils.GetEnvDuration(key, *dst)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*dst = d
+	}
+	integer := func(key string, dst *int) {
+		i, err := utils.GetEnvInt(key, *dst)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*dst = i
+	}
+	boolean := func(key string, dst *bool) {
+		b, err := utils.GetEnvBool(key, *dst)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*dst = b
+	}
+
+	cfg.HTTP.Addr = utils.GetEnvString("HTTP_SERVER_PORT", cfg.HTTP.Addr)
+	duration("HTTP_READ_TIMEOUT", &cfg.HTTP.ReadTimeout)
+	duration("HTTP_WRITE_TIMEOUT", &cfg.HTTP.WriteTimeout)
+	duration("HTTP_IDLE_TIMEOUT", &cfg.HTTP.IdleTimeout)
+	duration("HTTP_HANDLER_TIMEOUT", &cfg.HTTP.HandlerTimeout)
+	duration("HTTP_DRAIN_DELAY", &cfg.HTTP.DrainDelay)
+	duration("HTTP_SHUTDOWN_TIMEOUT", &cfg.HTTP.ShutdownTimeout)
+	cfg.Services.User.Addr = utils.GetEnvString("USER_SERVICE_ADDR", cfg.Services.User.Addr)
+	duration("GRPC_DEFAULT_DEADLINE", &cfg.GRPC.DefaultDeadline)
+	if v := utils.GetEnvString("GRPC_DEADLINES", ""); v != "" {
+		if err := (*deadlines)(&cfg.GRPC.Deadlines).Set(v); err != nil {
+			errs = append(errs, fmt.Errorf("GRPC_DEADLINES: %w", err))
+		}
+	}
+	cfg.Swagger.Host = utils.GetEnvString("SWAGGER_HOST", cfg.Swagger.Host)
+	cfg.Swagger.Scheme = utils.GetEnvString("SWAGGER_SCHEME", cfg.Swagger.Scheme)
+	duration("AUTH_ACCESS_TOKEN_TTL", &cfg.Auth.AccessTokenTTL)
+	duration("AUTH_UPSTREAM_TOKEN_TTL", &cfg.Auth.UpstreamTokenTTL)
+	boolean("AUTH_REFRESH_ENABLED", &cfg.Auth.Refresh.Enabled)
+	duration("AUTH_REFRESH_TOKEN_TTL", &cfg.Auth.Refresh.TokenTTL)
+	integer("AUTH_REVOCATION_MAX_ENTRIES", &cfg.Auth.RevocationMaxEntries)
+	boolean("AUTH_CACHE_ENABLED", &cfg.Auth.Cache.Enabled)
+	duration("AUTH_CACHE_TTL", &cfg.Auth.Cache.TTL)
+	integer("AUTH_CACHE_MAX_ENTRIES", &cfg.Auth.Cache.MaxEntries)
+	boolean("AUTH_JWT_ENABLED", &cfg.Auth.JWT.Enabled)
+	cfg.Auth.JWT.JWKSURL = utils.GetEnvString("AUTH_JWT_JWKS_URL", cfg.Auth.JWT.JWKSURL)
+	cfg.Auth.JWT.JWKSFile = utils.GetEnvString("AUTH_JWT_JWKS_FILE", cfg.Auth.JWT.JWKSFile)
+	duration("AUTH_JWT_REFRESH_INTERVAL", &cfg.Auth.JWT.RefreshInterval)
+	cfg.Auth.JWT.Issuer = utils.GetEnvString("AUTH_JWT_ISSUER", cfg.Auth.JWT.Issuer)
+	cfg.Auth.JWT.Audience = utils.GetEnvString("AUTH_JWT_AUDIENCE", cfg.Auth.JWT.Audience)
+	if v := utils.GetEnvString("AUTH_JWT_ALGORITHMS", ""); v != "" {
+		cfg.Auth.JWT.Algorithms = strings.Split(v, ",")
+	}
+	cfg.Auth.Policy.File = utils.GetEnvString("AUTH_POLICY_FILE", cfg.Auth.Policy.File)
+	duration("AUTH_POLICY_RELOAD_INTERVAL", &cfg.Auth.Policy.ReloadInterval)
+	boolean("AUTH_LOCKOUT_ENABLED", &cfg.Auth.Lockout.Enabled)
+	integer("AUTH_LOCKOUT_EMAIL_THRESHOLD", &cfg.Auth.Lockout.Email.Threshold)
+	duration("AUTH_LOCKOUT_EMAIL_LOCK_DURATION", &cfg.Auth.Lockout.Email.LockDuration)
+	integer("AUTH_LOCKOUT_IP_THRESHOLD", &cfg.Auth.Lockout.IP.Threshold)
+	duration("AUTH_LOCKOUT_IP_LOCK_DURATION", &cfg.Auth.Lockout.IP.LockDuration)
+	boolean("AUTH_ENUMERATION_ENABLED", &cfg.Auth.Enumeration.Enabled)
+	duration("AUTH_ENUMERATION_LATENCY_BUDGET", &cfg.Auth.Enumeration.LatencyBudget)
+	boolean("AUTH_ENUMERATION_CREATE_ACCEPTED", &cfg.Auth.Enumeration.CreateAccepted)
+	boolean("AUTH_PASSWORD_ENABLED", &cfg.Auth.Password.Enabled)
+	integer("AUTH_PASSWORD_MIN_LENGTH", &cfg.Auth.Password.MinLength)
+	integer("AUTH_PASSWORD_MIN_CLASSES", &cfg.Auth.Password.MinClasses)
+	cfg.Auth.Password.BreachedDir = utils.GetEnvString("AUTH_PASSWORD_BREACHED_DIR", cfg.Auth.Password.BreachedDir)
+	cfg.Log.Format = utils.GetEnvString("LOG_FORMAT", cfg.Log.Format)
+	cfg.Log.Level = utils.GetEnvString("LOG_LEVEL", cfg.Log.Level)
+	cfg.Admin.Addr = utils.GetEnvString("ADMIN_ADDR", cfg.Admin.Addr)
+	duration("HEALTH_STARTUP_GRACE", &cfg.Health.StartupGrace)
+	duration("HEALTH_TIMEOUT", &cfg.Health.Timeout)
+	duration("HEALTH_MAX_AGE", &cfg.Health.MaxAge)
+	cfg.Tracing.Exporter = utils.GetEnvString("TRACING_EXPORTER", cfg.Tracing.Exporter)
+	cfg.Tracing.Endpoint = utils.GetEnvString("TRACING_ENDPOINT", cfg.Tracing.Endpoint)
+	boolean("TRACING_INSECURE", &cfg.Tracing.Insecure)
+	boolean("RATE_LIMIT_ENABLED", &cfg.RateLimit.Enabled)
+	if v := utils.GetEnvString("RATE_LIMIT_TRUSTED_PROXIES", ""); v != "" {
+		cfg.RateLimit.TrustedProxies = strings.Split(v, ",")
+	}
+	if v := utils.GetEnvString("RATE_LIMIT_API_KEYS", ""); v != "" {
+		cfg.RateLimit.APIKeys = strings.Split(v, ",")
+	}
+	boolean("AUTH_COOKIE_ENABLED", &cfg.Auth.Cookie.Enabled)
+	cfg.Auth.Cookie.Name = utils.GetEnvString("AUTH_COOKIE_NAME", cfg.Auth.Cookie.Name)
+	cfg.Auth.Cookie.Domain = utils.GetEnvString("AUTH_COOKIE_DOMAIN", cfg.Auth.Cookie.Domain)
+	cfg.Auth.Cookie.SameSite = utils.GetEnvString("AUTH_COOKIE_SAME_SITE", cfg.Auth.Cookie.SameSite)
+
+	return errors.Join(errs...)
+}
+
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("gateway", flag.ContinueOnError)
+	fs.StringVar(&cfg.HTTP.Addr, "http-addr", cfg.HTTP.Addr, "public http listen address")
+	fs.DurationVar(&cfg.HTTP.ReadTimeout, "http-read-timeout", cfg.HTTP.ReadTimeout, "http server read timeout")
+	fs.DurationVar(&cfg.HTTP.WriteTimeout, "http-write-timeout", cfg.HTTP.WriteTimeout, "http server write timeout")
+	fs.DurationVar(&cfg.HTTP.IdleTimeout, "http-idle-timeout", cfg.HTTP.IdleTimeout, "http server idle timeout")
+	fs.DurationVar(&cfg.HTTP.HandlerTimeout, "http-handler-timeout", cfg.HTTP.HandlerTimeout, "maximum time a handler may run")
+	fs.DurationVar(&cfg.HTTP.DrainDelay, "http-drain-delay", cfg.HTTP.DrainDelay, "how long to keep serving after a shutdown signal while reporting not ready")
+	fs.DurationVar(&cfg.HTTP.ShutdownTimeout, "http-shutdown-timeout", cfg.HTTP.ShutdownTimeout, "how long running requests may take to finish at shutdown")
+	fs.StringVar(&cfg.Services.User.Addr, "user-service-addr", cfg.Services.User.Addr, "user service gRPC address")
+	fs.DurationVar(&cfg.GRPC.DefaultDeadline, "grpc-default-deadline", cfg.GRPC.DefaultDeadline, "deadline for gRPC calls without a route specific one")
+	fs.Var((*deadlines)(&cfg.GRPC.Deadlines), "grpc-deadline", "per route gRPC deadline as route=duration, repeatable")
+	fs.StringVar(&cfg.Swagger.Host, "swagger-host", cfg.Swagger.Host, "host advertised in the swagger docs")
+	fs.StringVar(&cfg.Swagger.Scheme, "swagger-scheme", cfg.Swagger.Scheme, "scheme advertised in the swagger docs")
+	fs.DurationVar(&cfg.Auth.AccessTokenTTL, "auth-access-token-ttl", cfg.Auth.AccessTokenTTL, "lifetime of gateway issued access tokens")
+	fs.DurationVar(&cfg.Auth.UpstreamTokenTTL, "auth-upstream-token-ttl", cfg.Auth.UpstreamTokenTTL, "lifetime assumed for user service tokens without an expiry")
+	fs.BoolVar(&cfg.Auth.Refresh.Enabled, "auth-refresh", cfg.Auth.Refresh.Enabled, "issue gateway access tokens and rotating refresh tokens")
+	fs.DurationVar(&cfg.Auth.Refresh.TokenTTL, "auth-refresh-token-ttl", cfg.Auth.Refresh.TokenTTL, "lifetime of refresh tokens")
+	fs.BoolVar(&cfg.Auth.Cache.Enabled, "auth-cache", cfg.Auth.Cache.Enabled, "cache AuthUser responses")
+	fs.DurationVar(&cfg.Auth.Cache.TTL, "auth-cache-ttl", cfg.Auth.Cache.TTL, "how long AuthUser responses are cached")
+	fs.BoolVar(&cfg.Auth.JWT.Enabled, "auth-jwt", cfg.Auth.JWT.Enabled, "verify user service JWTs locally against a JWKS")
+	fs.StringVar(&cfg.Auth.JWT.JWKSURL, "auth-jwt-jwks-url", cfg.Auth.JWT.JWKSURL, "URL of the JWKS used to verify user service JWTs")
+	fs.StringVar(&cfg.Auth.JWT.JWKSFile, "auth-jwt-jwks-file", cfg.Auth.JWT.JWKSFile, "file holding the JWKS used to verify user service JWTs")
+	fs.StringVar(&cfg.Auth.Policy.File, "auth-policy-file", cfg.Auth.Policy.File, "authorization policy file, reloaded when it changes")
+	fs.BoolVar(&cfg.Auth.Lockout.Enabled, "auth-lockout", cfg.Auth.Lockout.Enabled, "delay and lock out repeated failed logins")
+	fs.BoolVar(&cfg.Auth.Enumeration.Enabled, "auth-enumeration", cfg.Auth.Enumeration.Enabled, "answer create, reset-password and verify uniformly whether or not the account exists")
+	fs.DurationVar(&cfg.Auth.Enumeration.LatencyBudget, "auth-enumeration-latency-budget", cfg.Auth.Enumeration.LatencyBudget, "latency create, reset-password and verify responses are padded to")
+	fs.BoolVar(&cfg.Auth.Password.Enabled, "auth-password-policy", cfg.Auth.Password.Enabled, "check new passwords against the password policy")
+	fs.StringVar(&cfg.Auth.Password.BreachedDir, "auth-password-breached-dir", cfg.Auth.Password.BreachedDir, "directory of SHA-1 range files of breached passwords")
+	fs.BoolVar(&cfg.Auth.Cookie.Enabled, "auth-cookie", cfg.Auth.Cookie.Enabled, "set the access token as a Secure, HttpOnly cookie on login")
+	fs.StringVar(&cfg.Auth.Cookie.Domain, "auth-cookie-domain", cfg.Auth.Cookie.Domain, "domain of the access token cookie")
+	fs.StringVar(&cfg.Log.Format, "log-format", cfg.Log.Format, "log output format, text or json")
+	fs.StringVar(&cfg.Log.Level, "log-level", cfg.Log.Level, "lowest level logged: debug, info, warn or error")
+	fs.DurationVar(&cfg.Health.StartupGrace, "health-startup-grace", cfg.Health.StartupGrace, "how long after startup unreachable backends are reported as starting")
+	fs.StringVar(&cfg.Admin.Addr, "admin-addr", cfg.Admin.Addr, "admin listen address for metrics, empty to turn it off")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "where spans are exported: none, stdout or otlp")
+	fs.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", cfg.Tracing.Endpoint, "OTLP gRPC collector address")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", cfg.Tracing.SampleRatio, "share of new traces that are recorded")
+	fs.BoolVar(&cfg.RateLimit.Enabled, "rate-limit", cfg.RateLimit.Enabled, "rate limit requests per client")
+	return fs
+}
+
+// deadlines is a flag.Value for route=duration pairs separated by commas.
+type deadlines map[string]time.Duration
+
+func (d *deadlines) String() string {
+	if d == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(*d))
+	for _, route := range sortedKeys(*d) {
+		pairs = append(pairs, route+"="+(*d)[route].String())
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (d *deadlines) Set(value string) error {
+	if *d == nil {
+		*d = map[string]time.Duration{}
+	}
+	for _, pair := range strings.Split(value, ",") {
+		route, raw, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("%q is not in route=duration form", pair)
+		}
+		dur, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%q: %q is not a valid duration", route, raw)
+		}
+		(*d)[route] = dur
+	}
+	return nil
+}
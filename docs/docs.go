@@ -18,6 +18,221 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/v1/admin/lockouts": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "List the emails and addresses that are locked out or waiting out the delay after a failed login, most recent failure first. Requires the lockouts:manage permission.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "List Login Lockouts",
+                "parameters": [
+                    {
+                        "enum": [
+                            "email",
+                            "ip"
+                        ],
+                        "type": "string",
+                        "description": "Only list keys of this kind",
+                        "name": "kind",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.LockoutsResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Forget the failed logins of an email or address, lifting its lock and delay. Requires the lockouts:manage permission.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Clear Login Lockout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Email, exclusive with ip",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client address, exclusive with email",
+                        "name": "ip",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.MessageResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/admin/lockouts/status": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Show the failed logins recorded for an email or address. Requires the lockouts:manage permission.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Admin"
+                ],
+                "summary": "Get Login Lockout",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Email, exclusive with ip",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Client address, exclusive with email",
+                        "name": "ip",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/lockout.Status"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            }
+        },
         "/v1/users/add-editor": {
             "post": {
                 "security": [
@@ -55,13 +270,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -69,7 +302,7 @@ const docTemplate = `{
         },
         "/v1/users/create": {
             "post": {
-                "description": "Create a new user",
+                "description": "Create a new user. The password must meet the password policy; every rule it breaks is listed in the 400 response. With enumeration protection on, which is the default, the response is the same whether or not the email is already taken, 201 or 202 when create_accepted is set, and is sent no sooner than the latency budget.",
                 "consumes": [
                     "application/json"
                 ],
@@ -89,6 +322,12 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.CreateUserRequest"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Lets the gateway retry the call to the user service, which deduplicates sign ups by it",
+                        "name": "Idempotency-Key",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -98,16 +337,40 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.MessageResponse"
                         }
                     },
+                    "202": {
+                        "description": "Accepted",
+                        "schema": {
+                            "$ref": "#/definitions/main.MessageResponse"
+                        }
+                    },
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -115,7 +378,7 @@ const docTemplate = `{
         },
         "/v1/users/login": {
             "post": {
-                "description": "Login to an existing user",
+                "description": "Login to an existing user. The access token is returned in the body and, when enabled, also set as a Secure, HttpOnly cookie. After a failed login the email and client address have to wait before trying again, and repeated failures lock them out for a while; such attempts get a 429 with Retry-After.",
                 "consumes": [
                     "application/json"
                 ],
@@ -137,6 +400,67 @@ const docTemplate = `{
                         }
                     }
                 ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TokenResponse"
+                        },
+                        "headers": {
+                            "Set-Cookie": {
+                                "type": "string",
+                                "description": "Access token cookie, only when enabled"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/logout": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke the token used for this request. For gateway sessions the refresh token is revoked too.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Logout User",
                 "responses": {
                     "200": {
                         "description": "OK",
@@ -144,18 +468,73 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.MessageResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/logout-all": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Revoke every session of the current user on the gateway, including the token used for this request.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Logout User Everywhere",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/main.MessageResponse"
                         }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
                     }
                 }
             }
         },
         "/v1/users/reset-password": {
             "post": {
-                "description": "Reset the password of an existing user",
+                "description": "Reset the password of an existing user. With enumeration protection on, which is the default, the response is the same whether or not an account uses the email, and is sent no sooner than the latency budget.",
                 "consumes": [
                     "application/json"
                 ],
@@ -187,13 +566,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -206,7 +603,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Send an invite to a user to become an editor",
+                "description": "Send an invite to a user to become an editor. Requires the editors:invite permission.",
                 "consumes": [
                     "application/json"
                 ],
@@ -236,13 +633,43 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -282,10 +709,91 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.MessageResponse"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/users/token/refresh": {
+            "post": {
+                "description": "Exchange a refresh token for a new access and refresh token. Every refresh token can be used once; using one again revokes the whole session. The token is read from the body or, when cookies are enabled, from the refresh token cookie.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Refresh Token",
+                "parameters": [
+                    {
+                        "description": "Refresh token",
+                        "name": "data",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/main.RefreshTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TokenResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -293,7 +801,7 @@ const docTemplate = `{
         },
         "/v1/users/update-password": {
             "post": {
-                "description": "Update the password of an existing user",
+                "description": "Update the password of an existing user. The password must meet the password policy; every rule it breaks is listed in the 400 response.",
                 "consumes": [
                     "application/json"
                 ],
@@ -332,13 +840,31 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -351,7 +877,7 @@ const docTemplate = `{
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Update the role of an existing user",
+                "description": "Update the role of an existing user. Requires the users:update_role permission.",
                 "consumes": [
                     "application/json"
                 ],
@@ -383,13 +909,43 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -397,7 +953,7 @@ const docTemplate = `{
         },
         "/v1/users/verify": {
             "get": {
-                "description": "Verify a existing user",
+                "description": "Verify a existing user. With enumeration protection on, which is the default, an unknown user and an expired token both get 401 auth.token_invalid, no sooner than the latency budget.",
                 "produces": [
                     "application/json"
                 ],
@@ -424,25 +980,37 @@ const docTemplate = `{
                     "400": {
                         "description": "Bad Request",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "401": {
                         "description": "Unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "429": {
+                        "description": "Too Many Requests",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/main.MessageResponse"
+                            "$ref": "#/definitions/main.ProblemDetails"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.ProblemDetails"
                         }
                     }
                 }
@@ -450,28 +1018,194 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "lockout.Kind": {
+            "type": "string",
+            "enum": [
+                "email",
+                "ip"
+            ],
+            "x-enum-varnames": [
+                "KindEmail",
+                "KindIP"
+            ]
+        },
+        "lockout.Status": {
+            "type": "object",
+            "properties": {
+                "failures": {
+                    "type": "integer"
+                },
+                "in_flight": {
+                    "description": "InFlight counts the attempts let through and not settled yet.",
+                    "type": "integer"
+                },
+                "key": {
+                    "type": "string"
+                },
+                "kind": {
+                    "$ref": "#/definitions/lockout.Kind"
+                },
+                "last_failure": {
+                    "type": "string"
+                },
+                "locked_until": {
+                    "type": "string"
+                },
+                "locks": {
+                    "type": "integer"
+                },
+                "next_attempt": {
+                    "description": "NextAttempt is when the key may try again after its last failure.",
+                    "type": "string"
+                }
+            }
+        },
         "main.CreateUserRequest": {
             "type": "object",
+            "required": [
+                "email",
+                "name",
+                "password"
+            ],
             "properties": {
                 "email": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 254
                 },
                 "name": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 100
                 },
                 "password": {
+                    "type": "string",
+                    "maxLength": 72,
+                    "minLength": 10
+                }
+            }
+        },
+        "main.ErrorCode": {
+            "type": "string",
+            "enum": [
+                "request.invalid_payload",
+                "request.missing_parameter",
+                "request.validation_failed",
+                "request.too_large",
+                "request.invalid_argument",
+                "request.canceled",
+                "route.not_found",
+                "route.method_not_allowed",
+                "auth.unauthorized",
+                "auth.invalid_credentials",
+                "auth.token_invalid",
+                "auth.token_expired",
+                "auth.token_revoked",
+                "auth.refresh_token_invalid",
+                "auth.refresh_token_reused",
+                "auth.forbidden",
+                "user.not_found",
+                "user.already_exists",
+                "resource.not_found",
+                "resource.conflict",
+                "auth.login_locked",
+                "rate_limit.exceeded",
+                "service.unavailable",
+                "service.timeout",
+                "service.not_implemented",
+                "internal.error"
+            ],
+            "x-enum-comments": {
+                "ErrCodeCanceled": "The request was canceled before it completed.",
+                "ErrCodeConflict": "The request conflicts with the current state.",
+                "ErrCodeForbidden": "The user is not allowed to perform the action.",
+                "ErrCodeInternal": "Unexpected failure, report the request_id.",
+                "ErrCodeInvalidArgument": "The service rejected an argument.",
+                "ErrCodeInvalidCredentials": "The email or password is wrong.",
+                "ErrCodeInvalidPayload": "Body is not valid JSON for the endpoint.",
+                "ErrCodeLoginLocked": "Too many failed logins, retry after Retry-After.",
+                "ErrCodeMethodNotAllowed": "The endpoint does not support the method.",
+                "ErrCodeMissingParameter": "A required query or path parameter is missing or malformed.",
+                "ErrCodeNotFound": "The referenced resource does not exist.",
+                "ErrCodeNotImplemented": "The backend does not support the operation.",
+                "ErrCodePayloadTooLarge": "The body exceeds the size limit.",
+                "ErrCodeRateLimited": "Too many requests, retry after Retry-After.",
+                "ErrCodeRefreshInvalid": "The refresh token is unknown, expired or its session ended.",
+                "ErrCodeRefreshReused": "The refresh token was already used, the session was revoked.",
+                "ErrCodeRouteNotFound": "No endpoint matches the path.",
+                "ErrCodeTimeout": "A backend service did not answer in time.",
+                "ErrCodeTokenExpired": "The token has expired.",
+                "ErrCodeTokenInvalid": "The token is not recognized.",
+                "ErrCodeTokenRevoked": "The token was revoked by a logout.",
+                "ErrCodeUnauthorized": "Credentials are missing or malformed.",
+                "ErrCodeUnavailable": "A backend service is unavailable.",
+                "ErrCodeUserExists": "A user with the same email already exists.",
+                "ErrCodeUserNotFound": "The referenced user does not exist.",
+                "ErrCodeValidation": "One or more fields failed validation, see errors."
+            },
+            "x-enum-varnames": [
+                "ErrCodeInvalidPayload",
+                "ErrCodeMissingParameter",
+                "ErrCodeValidation",
+                "ErrCodePayloadTooLarge",
+                "ErrCodeInvalidArgument",
+                "ErrCodeCanceled",
+                "ErrCodeRouteNotFound",
+                "ErrCodeMethodNotAllowed",
+                "ErrCodeUnauthorized",
+                "ErrCodeInvalidCredentials",
+                "ErrCodeTokenInvalid",
+                "ErrCodeTokenExpired",
+                "ErrCodeTokenRevoked",
+                "ErrCodeRefreshInvalid",
+                "ErrCodeRefreshReused",
+                "ErrCodeForbidden",
+                "ErrCodeUserNotFound",
+                "ErrCodeUserExists",
+                "ErrCodeNotFound",
+                "ErrCodeConflict",
+                "ErrCodeLoginLocked",
+                "ErrCodeRateLimited",
+                "ErrCodeUnavailable",
+                "ErrCodeTimeout",
+                "ErrCodeNotImplemented",
+                "ErrCodeInternal"
+            ]
+        },
+        "main.FieldError": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "field": {
                     "type": "string"
                 }
             }
         },
+        "main.LockoutsResponse": {
+            "type": "object",
+            "properties": {
+                "lockouts": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/lockout.Status"
+                    }
+                }
+            }
+        },
         "main.LoginUserRequest": {
             "type": "object",
+            "required": [
+                "email",
+                "password"
+            ],
             "properties": {
                 "email": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 254
                 },
                 "password": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 1024
                 }
             }
         },
@@ -483,30 +1217,117 @@ const docTemplate = `{
                 }
             }
         },
+        "main.ProblemDetails": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "$ref": "#/definitions/main.ErrorCode"
+                },
+                "detail": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.FieldError"
+                    }
+                },
+                "instance": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.RefreshTokenRequest": {
+            "type": "object",
+            "properties": {
+                "refresh_token": {
+                    "description": "RefreshToken may be left out when it is sent in the refresh cookie.",
+                    "type": "string",
+                    "maxLength": 256
+                }
+            }
+        },
         "main.ResetUserPasswordRequest": {
             "type": "object",
+            "required": [
+                "email"
+            ],
             "properties": {
                 "email": {
+                    "type": "string",
+                    "maxLength": 254
+                }
+            }
+        },
+        "main.TokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "expires_in": {
+                    "type": "integer"
+                },
+                "refresh_token": {
+                    "type": "string"
+                },
+                "refresh_token_expires_at": {
+                    "type": "string"
+                },
+                "refresh_token_expires_in": {
+                    "type": "integer"
+                },
+                "token_type": {
                     "type": "string"
                 }
             }
         },
         "main.UpdateUserPasswordRequest": {
             "type": "object",
+            "required": [
+                "password"
+            ],
             "properties": {
                 "password": {
-                    "type": "string"
+                    "type": "string",
+                    "maxLength": 72,
+                    "minLength": 10
                 }
             }
         },
         "main.UpdateUserRoleRequest": {
             "type": "object",
+            "required": [
+                "role_name",
+                "userId"
+            ],
             "properties": {
                 "role_name": {
-                    "type": "string"
+                    "type": "string",
+                    "enum": [
+                        "admin",
+                        "editor",
+                        "user"
+                    ]
                 },
-                "user_id": {
-                    "type": "string"
+                "userId": {
+                    "type": "integer",
+                    "minimum": 1
                 }
             }
         }
@@ -527,7 +1348,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/v1",
 	Schemes:          []string{},
 	Title:            "InstaUpload",
-	Description:      "This is swagger api page for InstaUpload gateway service.",
+	Description:      "This is swagger api page for InstaUpload gateway service.\nErrors are returned as RFC 7807 application/problem+json bodies (main.ProblemDetails); clients should branch on the code field, the catalog of codes is listed in main.ErrorCode.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/InstaUpload/gateway/logging"
+)
+
+// recordOutcome logs and counts what really happened to a request to
+// endpoint, which the response may not tell when enumeration protection
+// is on.
+func (h *Handler) recordOutcome(r *http.Request, endpoint, outcome string) {
+	if h.outcomes == nil {
+		return
+	}
+	h.outcomes.Add(endpoint+"."+outcome, 1)
+	slog.InfoContext(r.Context(), "request outcome", logging.Auth, "endpoint", endpoint, "outcome", outcome)
+}
+
+// padLatency holds the response of endpoint back until the latency budget
+// has passed since the request arrived, so an answer for an unknown
+// account is not faster than one for an existing account. Responses that
+// take longer than the budget are sent as they are and counted.
+func (h *Handler) padLatency(endpoint string) func(http.Handler) http.Handler {
+	if h.outcomes == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	budget := h.cfg.Auth.Enumeration.LatencyBudget
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			buf := &bufferedResponse{header: http.Header{}}
+			next.ServeHTTP(buf, r)
+			if wait := budget - time.Since(start); wait > 0 {
+				t := time.NewTimer(wait)
+				select {
+				case <-t.C:
+				case <-r.Context().Done():
+					t.Stop()
+				}
+			} else {
+				h.outcomes.Add(endpoint+".over_budget", 1)
+			}
+			buf.writeTo(w)
+		})
+	}
+}
+
+// bufferedResponse keeps a response in memory until writeTo sends it.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *bufferedResponse) Header() http.Header { return b.header }
+
+func (b *bufferedResponse) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	return b.body.Write(p)
+}
+
+func (b *bufferedResponse) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedResponse) writeTo(w http.ResponseWriter) {
+	for k, v := range b.header {
+		w.Header()[k] = v
+	}
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	w.WriteHeader(b.status)
+	if _, err := b.body.WriteTo(w); err != nil {
+		slog.Error("error sending response", logging.HTTP, "err", err)
+	}
+}
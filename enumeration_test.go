@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	pb "github.com/InstaUpload/common/api"
+	"github.com/InstaUpload/gateway/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userService answers CreateUser with err.
+type userService struct {
+	pb.UserServiceClient
+	err error
+}
+
+func (s userService) CreateUser(context.Context, *pb.CreateUserRequest, ...grpc.CallOption) (*pb.CreateUserResponse, error) {
+	return &pb.CreateUserResponse{}, s.err
+}
+
+func create(h *Handler) (int, string) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/create", strings.NewReader(`{"name":"Jo","email":"jo@example.com","password":"correct horse battery"}`))
+	rec := httptest.NewRecorder()
+	h.CreateUser(rec, req)
+	return rec.Code, rec.Body.String()
+}
+
+func TestCreateAnswersAlikeByDefault(t *testing.T) {
+	for _, accepted := range []bool{false, true} {
+		cfg := config.Default()
+		cfg.Auth.Enumeration.CreateAccepted = accepted
+		want := http.StatusCreated
+		if accepted {
+			want = http.StatusAccepted
+		}
+
+		created := &Handler{cfg: cfg, outcomes: new(expvar.Map).Init(), userClient: userService{}}
+		exists := &Handler{cfg: cfg, outcomes: new(expvar.Map).Init(), userClient: userService{err: status.Error(codes.AlreadyExists, "taken")}}
+		newCode, newBody := create(created)
+		takenCode, takenBody := create(exists)
+		if newCode != want || takenCode != want || newBody != takenBody {
+			t.Errorf("create_accepted %v: new account %d %s, taken email %d %s, want both %d alike",
+				accepted, newCode, newBody, takenCode, takenBody, want)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	common "github.com/InstaUpload/common/types"
+	"github.com/InstaUpload/gateway/logging"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode is the stable, machine readable identifier of an error. Clients
+// should branch on it rather than on the http status or the detail text.
+type ErrorCode string
+
+const (
+	ErrCodeInvalidPayload     ErrorCode = "request.invalid_payload"    // Body is not valid JSON for the endpoint.
+	ErrCodeMissingParameter   ErrorCode = "request.missing_parameter"  // A required query or path parameter is missing or malformed.
+	ErrCodeValidation         ErrorCode = "request.validation_failed"  // One or more fields failed validation, see errors.
+	ErrCodePayloadTooLarge    ErrorCode = "request.too_large"          // The body exceeds the size limit.
+	ErrCodeInvalidArgument    ErrorCode = "request.invalid_argument"   // The service rejected an argument.
+	ErrCodeCanceled           ErrorCode = "request.canceled"           // The request was canceled before it completed.
+	ErrCodeRouteNotFound      ErrorCode = "route.not_found"            // No endpoint matches the path.
+	ErrCodeMethodNotAllowed   ErrorCode = "route.method_not_allowed"   // The endpoint does not support the method.
+	ErrCodeUnauthorized       ErrorCode = "auth.unauthorized"          // Credentials are missing or malformed.
+	ErrCodeInvalidCredentials ErrorCode = "auth.invalid_credentials"   // The email or password is wrong.
+	ErrCodeTokenInvalid       ErrorCode = "auth.token_invalid"         // The token is not recognized.
+	ErrCodeTokenExpired       ErrorCode = "auth.token_expired"         // The token has expired.
+	ErrCodeTokenRevoked       ErrorCode = "auth.token_revoked"         // The token was revoked by a logout.
+	ErrCodeRefreshInvalid     ErrorCode = "auth.refresh_token_invalid" // The refresh token is unknown, expired or its session ended.
+	ErrCodeRefreshReused      ErrorCode = "auth.refresh_token_reused"  // The refresh token was already used, the session was revoked.
+	ErrCodeForbidden          ErrorCode = "auth.forbidden"             // The user is not allowed to perform the action.
+	ErrCodeUserNotFound       ErrorCode = "user.not_found"             // The referenced user does not exist.
+	ErrCodeUserExists         ErrorCode = "user.already_exists"        // A user with the same email already exists.
+	ErrCodeNotFound           ErrorCode = "resource.not_found"         // The referenced resource does not exist.
+	ErrCodeConflict           ErrorCode = "resource.conflict"          // The request conflicts with the current state.
+	ErrCodeLoginLocked        ErrorCode = "auth.login_locked"          // Too many failed logins, retry after Retry-After.
+	ErrCodeRateLimited        ErrorCode = "rate_limit.exceeded"        // Too many requests, retry after Retry-After.
+	ErrCodeUnavailable        ErrorCode = "service.unavailable"        // A backend service is unavailable.
+	ErrCodeTimeout            ErrorCode = "service.timeout"            // A backend service did not answer in time.
+	ErrCodeNotImplemented     ErrorCode = "service.not_implemented"    // The backend does not support the operation.
+	ErrCodeInternal           ErrorCode = "internal.error"             // Unexpected failure, report the request_id.
+)
+
+type errorSpec struct {
+	Status int
+	Title  string
+}
+
+// errorCatalog holds the http status and title of every ErrorCode.
+var errorCatalog = map[ErrorCode]errorSpec{
+	ErrCodeInvalidPayload:     {http.StatusBadRequest, "Invalid request payload"},
+	ErrCodeMissingParameter:   {http.StatusBadRequest, "Missing or invalid parameter"},
+	ErrCodeValidation:         {http.StatusBadRequest, "Validation failed"},
+	ErrCodePayloadTooLarge:    {http.StatusRequestEntityTooLarge, "Request payload too large"},
+	ErrCodeInvalidArgument:    {http.StatusBadRequest, "Invalid request"},
+	ErrCodeCanceled:           {http.StatusRequestTimeout, "Request canceled"},
+	ErrCodeRouteNotFound:      {http.StatusNotFound, "Route not found"},
+	ErrCodeMethodNotAllowed:   {http.StatusMethodNotAllowed, "Method not allowed"},
+	ErrCodeUnauthorized:       {http.StatusUnauthorized, "Unauthorized"},
+	ErrCodeInvalidCredentials: {http.StatusUnauthorized, "Invalid credentials"},
+	ErrCodeTokenInvalid:       {http.StatusUnauthorized, "Invalid token"},
+	ErrCodeTokenExpired:       {http.StatusUnauthorized, "Token expired"},
+	ErrCodeTokenRevoked:       {http.StatusUnauthorized, "Token revoked"},
+	ErrCodeRefreshInvalid:     {http.StatusUnauthorized, "Invalid refresh token"},
+	ErrCodeRefreshReused:      {http.StatusUnauthorized, "Refresh token reused"},
+	ErrCodeForbidden:          {http.StatusForbidden, "Forbidden"},
+	ErrCodeUserNotFound:       {http.StatusNotFound, "User not found"},
+	ErrCodeUserExists:         {http.StatusConflict, "User already exists"},
+	ErrCodeNotFound:           {http.StatusNotFound, "Not found"},
+	ErrCodeConflict:           {http.StatusConflict, "Conflict"},
+	ErrCodeLoginLocked:        {http.StatusTooManyRequests, "Too many failed logins"},
+	ErrCodeRateLimited:        {http.StatusTooManyRequests, "Too many requests"},
+	ErrCodeUnavailable:        {http.StatusServiceUnavailable, "Service unavailable"},
+	ErrCodeTimeout:            {http.StatusGatewayTimeout, "Service took too long to respond"},
+	ErrCodeNotImplemented:     {http.StatusNotImplemented, "Not implemented"},
+	ErrCodeInternal:           {http.StatusInternalServerError, "Internal server error"},
+}
+
+type FieldError struct {
+	Field       string `json:"field"`
+	Description string `json:"description"`
+}
+
+// httpError is the error code and detail a gRPC code is translated to.
+type httpError struct {
+	Code   ErrorCode
+	Detail string
+}
+
+// grpcErrors is the default translation of gRPC codes. Handlers pass
+// overrides to sendGRPCError when a code means something more specific for
+// their endpoint.
+var grpcErrors = map[codes.Code]httpError{
+	codes.InvalidArgument:    {ErrCodeInvalidArgument, ""},
+	codes.FailedPrecondition: {ErrCodeInvalidArgument, "Request can not be processed in the current state"},
+	codes.OutOfRange:         {ErrCodeInvalidArgument, ""},
+	codes.NotFound:           {ErrCodeNotFound, ""},
+	codes.AlreadyExists:      {ErrCodeConflict, "Already exists"},
+	codes.Aborted:            {ErrCodeConflict, "Request conflicted with another request"},
+	codes.Unauthenticated:    {ErrCodeUnauthorized, ""},
+	codes.PermissionDenied:   {ErrCodeForbidden, ""},
+	codes.ResourceExhausted:  {ErrCodeRateLimited, ""},
+	codes.Canceled:           {ErrCodeCanceled, ""},
+	codes.DeadlineExceeded:   {ErrCodeTimeout, ""},
+	codes.Unavailable:        {ErrCodeUnavailable, ""},
+	codes.Unimplemented:      {ErrCodeNotImplemented, ""},
+}
+
+// legacyErrors maps the sentinel errors of the common module to gRPC codes.
+// Services that return them unwrapped reach us as codes.Unknown with the
+// sentinel's text as message.
+var legacyErrors = map[string]codes.Code{
+	common.ErrDataNotFound.Error():          codes.NotFound,
+	common.ErrDataFound.Error():             codes.AlreadyExists,
+	common.ErrIncorrectDataReceived.Error(): codes.InvalidArgument,
+	common.ErrUnauthorized.Error():          codes.Unauthenticated,
+}
+
+// grpcStatus converts err into a *status.Status, resolving context errors
+// and legacy sentinel errors to their proper codes.
+func grpcStatus(err error) *status.Status {
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.FromContextError(err)
+	}
+	if st.Code() == codes.Unknown {
+		if code, ok := legacyErrors[st.Message()]; ok {
+			return status.New(code, st.Message())
+		}
+	}
+	return st
+}
+
+// sendGRPCError writes the problem response for an error returned by a
+// gRPC client. action describes what failed and is only used for logging.
+func sendGRPCError(w http.ResponseWriter, r *http.Request, err error, action string, overrides map[codes.Code]httpError) {
+	st := grpcStatus(err)
+	httpErr, ok := overrides[st.Code()]
+	if !ok {
+		httpErr, ok = grpcErrors[st.Code()]
+	}
+	if !ok {
+		httpErr = httpError{Code: ErrCodeInternal}
+	}
+	if errorCatalog[httpErr.Code].Status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "error "+action, logging.GRPCClient, "err", err)
+	}
+
+	var fields []FieldError
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				fields = append(fields, FieldError{Field: v.GetField(), Description: v.GetDescription()})
+			}
+		case *errdetails.RetryInfo:
+			if delay := d.GetRetryDelay().AsDuration(); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int((delay+time.Second-1)/time.Second)))
+			}
+		case *errdetails.LocalizedMessage:
+			if errorCatalog[httpErr.Code].Status < http.StatusInternalServerError && d.GetMessage() != "" {
+				httpErr.Detail = d.GetMessage()
+			}
+		}
+	}
+	if len(fields) > 0 && httpErr.Code == ErrCodeInvalidArgument {
+		httpErr.Code = ErrCodeValidation
+	}
+	SendProblemResponse(w, r, httpErr.Code, httpErr.Detail, fields...)
+}
@@ -1,10 +1,27 @@
 package main
 
 import (
+	"context"
+	"expvar"
+	"fmt"
 	"net/http"
-	"time"
+	"net/netip"
+	"sync/atomic"
 
 	pb "github.com/InstaUpload/common/api"
+	"github.com/InstaUpload/gateway/authcache"
+	"github.com/InstaUpload/gateway/authz"
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/health"
+	"github.com/InstaUpload/gateway/jwtauth"
+	"github.com/InstaUpload/gateway/lockout"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/metrics"
+	"github.com/InstaUpload/gateway/password"
+	"github.com/InstaUpload/gateway/ratelimit"
+	"github.com/InstaUpload/gateway/requestid"
+	"github.com/InstaUpload/gateway/session"
+	"github.com/InstaUpload/gateway/tracing"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	httpSwagger "github.com/swaggo/http-swagger/v2"
@@ -12,33 +29,101 @@ import (
 
 type Handler struct {
 	userClient pb.UserServiceClient
+	cfg        *config.Config
+	// sessions is nil when refresh tokens are disabled.
+	sessions *session.Manager
+	revoked  *session.RevocationList
+	// authCache is nil when AuthUser responses are not cached.
+	authCache *authcache.Cache
+	// jwt is nil when tokens are not verified locally.
+	jwt *jwtauth.Verifier
+	// policy holds the authorization policy, swapped when its file changes.
+	policy *authz.Engine
+	// limiter is nil when rate limiting is off.
+	limiter        ratelimit.Backend
+	trustedProxies []netip.Prefix
+	// lockout is nil when failed logins are not tracked.
+	lockout *lockout.Tracker
+	// outcomes counts the real outcomes of create, reset-password and
+	// verify. It is nil when their responses tell them apart.
+	outcomes *expvar.Map
+	// passwords is nil when new passwords are not checked at the gateway.
+	passwords       *password.Policy
+	passwordRejects *expvar.Map
+	// tracer is nil when tracing is disabled.
+	tracer  *tracing.Tracer
+	metrics *metrics.Metrics
+	health  *health.Checker
+	// inFlight counts the requests being handled.
+	inFlight atomic.Int64
+	// logLevels are changed on the admin listener.
+	logLevels *logging.Levels
 }
 
-func (h *Handler) mount() http.Handler {
+func (h *Handler) mount() *chi.Mux {
 	r := chi.NewRouter()
 	// Middleware
-	r.Use(middleware.Logger)
+	r.Use(h.countInFlight)
+	r.Use(requestid.Middleware)
+	r.Use(logging.Middleware)
+	r.Use(h.metrics.Middleware)
+	if h.tracer != nil {
+		r.Use(h.tracer.Middleware)
+	}
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	r.Use(middleware.Timeout(h.cfg.HTTP.HandlerTimeout))
 	r.Get("/swagger/*", httpSwagger.Handler(
-		httpSwagger.URL("http://localhost:5000/swagger/doc.json"), //The url pointing to API definition
+		httpSwagger.URL(fmt.Sprintf("%s://%s/swagger/doc.json", h.cfg.Swagger.Scheme, h.cfg.Swagger.Host)), //The url pointing to API definition
 	))
+	r.Get("/healthz", h.Healthz)
+	r.Get("/readyz", h.Readyz)
+	r.NotFound(func(w http.ResponseWriter, r *http.Request) {
+		SendProblemResponse(w, r, ErrCodeRouteNotFound, "")
+	})
+	r.MethodNotAllowed(func(w http.ResponseWriter, r *http.Request) {
+		SendProblemResponse(w, r, ErrCodeMethodNotAllowed, "")
+	})
 	r.Route("/v1", func(r chi.Router) {
 		r.Route("/users", func(r chi.Router) {
-			r.Post("/create", h.CreateUser)
-			r.Post("/login", h.LoginUser)
-			r.Get("/verify", h.VerifyUser)
-			r.Post("/reset-password", h.ResetUserPassword)
-			r.Post("/update-password", h.UpdateUserPassword)
+			r.With(h.rateLimit("create"), h.padLatency("create")).Post("/create", h.CreateUser)
+			r.With(h.rateLimit("login")).Post("/login", h.LoginUser)
+			if h.sessions != nil {
+				r.With(h.rateLimit("refresh")).Post("/token/refresh", h.RefreshToken)
+			}
+			r.With(h.rateLimit("verify"), h.padLatency("verify")).Get("/verify", h.VerifyUser)
+			r.With(h.rateLimit("reset_password"), h.padLatency("reset_password")).Post("/reset-password", h.ResetUserPassword)
+			r.With(h.rateLimit("update_password")).Post("/update-password", h.UpdateUserPassword)
 			r.Group(func(r chi.Router) {
-				r.Use(h.GetCurrentUser)
-				r.Put("/update-role", h.UpdateUserRole)
+				r.Use(h.GetCurrentUser, h.rateLimit("authenticated"), h.Authorize)
+				r.With(h.RequirePermission(authz.PermUpdateRole)).Put("/update-role", h.UpdateUserRole)
 				r.Get("/send-verify", h.SendVerifyUser)
 				r.Put("/add-editor", h.AddEditorUser)
-				r.Put("/send-editor-invite/{u}", h.SendEditorInvite)
+				r.With(h.RequirePermission(authz.PermInviteEditor)).Put("/send-editor-invite/{u}", h.SendEditorInvite)
+				r.Post("/logout", h.LogoutUser)
+				r.Post("/logout-all", h.LogoutAllUser)
 			})
 		})
+		if h.lockout != nil {
+			// A group rather than a subrouter, so Authorize runs once the
+			// route is matched and sees its full pattern.
+			r.Group(func(r chi.Router) {
+				r.Use(h.GetCurrentUser, h.rateLimit("authenticated"), h.Authorize, h.RequirePermission(authz.PermManageLockouts))
+				r.Get("/admin/lockouts", h.ListLockouts)
+				r.Get("/admin/lockouts/status", h.GetLockout)
+				r.Delete("/admin/lockouts", h.DeleteLockout)
+			})
+		}
 	})
 
 	return r
 }
+
+// grpcContext derives the context for a gRPC call from r, bounded by the
+// deadline configured for the route that matched r.
+func (h *Handler) grpcContext(r *http.Request) (context.Context, context.CancelFunc) {
+	route := ""
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		route = rctx.RoutePattern()
+	}
+	return context.WithTimeout(r.Context(), h.cfg.GRPC.Deadline(route))
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/InstaUpload/gateway/health"
+)
+
+// HealthResponse is the body of /healthz.
+type HealthResponse struct {
+	Status health.Status `json:"status"`
+}
+
+// Healthz reports that the process is up and answering requests. It does
+// not look at the backends, so an orchestrator does not restart the
+// gateway for an outage of the user service.
+func (h *Handler) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	SendJsonResponse(w, http.StatusOK, HealthResponse{Status: health.StatusOK})
+}
+
+// Readyz reports whether the gateway can serve requests, checking every
+// backend. It answers 503 unless all of them are ok, with the result of
+// each in the body. The errors of failed checks are left out, as anyone
+// can call it; the admin listener serves them with GetReadiness.
+func (h *Handler) Readyz(w http.ResponseWriter, r *http.Request) {
+	sendReadiness(w, h.health.Check(r.Context()).Public())
+}
+
+// GetReadiness is Readyz for operators, with the error of every failed
+// check.
+func (h *Handler) GetReadiness(w http.ResponseWriter, r *http.Request) {
+	sendReadiness(w, h.health.Check(r.Context()))
+}
+
+func sendReadiness(w http.ResponseWriter, report health.Report) {
+	code := http.StatusOK
+	if report.Status != health.StatusOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	SendJsonResponse(w, code, report)
+}
@@ -0,0 +1,198 @@
+// Package health reports whether the gateway can reach its backends.
+//
+// A Checker looks at every backend connection twice: at the state of its
+// grpc.ClientConn, and by asking the backend through the standard gRPC
+// health protocol. Backends that do not implement the protocol are judged
+// by their connection alone. For a grace period after startup, backends
+// that are not reachable yet are reported as starting rather than
+// unavailable, since their connections are only being set up.
+//
+// A report is reused for a while rather than checked again for every
+// caller, so polling readiness does not put load on the backends.
+package health
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Status is the health of a dependency or of the gateway as a whole.
+type Status string
+
+const (
+	StatusOK          Status = "ok"
+	StatusStarting    Status = "starting"
+	StatusUnavailable Status = "unavailable"
+	// StatusStopping is reported once the gateway is shutting down.
+	StatusStopping Status = "stopping"
+)
+
+// Dependency is a backend the gateway needs to serve requests.
+type Dependency struct {
+	Name string
+	Conn *grpc.ClientConn
+	// Service is the name asked for in the health check; empty asks for
+	// the server as a whole.
+	Service string
+}
+
+// Result is the health of one dependency.
+type Result struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	// State is the connectivity state of the connection, e.g. READY.
+	State string `json:"state"`
+	// Health is the answer to the health check, e.g. SERVING, or empty if
+	// the check was not made.
+	Health    string  `json:"health,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Report is the health of the gateway and each of its dependencies.
+type Report struct {
+	Status       Status   `json:"status"`
+	Dependencies []Result `json:"dependencies"`
+}
+
+// Public returns a copy of r without the errors of its dependencies,
+// which can name backend addresses, for callers outside the deployment.
+func (r Report) Public() Report {
+	deps := make([]Result, len(r.Dependencies))
+	for i, d := range r.Dependencies {
+		d.Error = ""
+		deps[i] = d
+	}
+	r.Dependencies = deps
+	return r
+}
+
+// Checker checks the dependencies.
+type Checker struct {
+	deps    []Dependency
+	started time.Time
+	grace   time.Duration
+	timeout time.Duration
+	maxAge  time.Duration
+	now     func() time.Time
+
+	stopping atomic.Bool
+
+	// mu is held for a whole check, so callers arriving during one wait
+	// for its report instead of starting their own.
+	mu        sync.Mutex
+	last      Report
+	checkedAt time.Time
+}
+
+// Stop makes every later Check report StatusStopping without checking the
+// dependencies, so load balancers stop sending requests before the
+// gateway stops taking them.
+func (c *Checker) Stop() {
+	c.stopping.Store(true)
+}
+
+// NewChecker returns a checker for deps, giving each health check timeout
+// to answer, reporting failures as starting until grace has passed and
+// reusing a report until it is maxAge old. A maxAge of zero checks on
+// every call.
+func NewChecker(deps []Dependency, grace, timeout, maxAge time.Duration) *Checker {
+	return &Checker{
+		deps:    deps,
+		started: time.Now(),
+		grace:   grace,
+		timeout: timeout,
+		maxAge:  maxAge,
+		now:     time.Now,
+		last:    Report{Status: StatusStarting},
+	}
+}
+
+// Check checks every dependency at once, or returns the last report if it
+// is younger than maxAge. The gateway is ok when all of them are, and
+// unavailable as soon as one of them is. The report is shared, so callers
+// must not change it.
+func (c *Checker) Check(ctx context.Context) Report {
+	if c.stopping.Load() {
+		return Report{Status: StatusStopping, Dependencies: []Result{}}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && c.now().Sub(c.checkedAt) < c.maxAge {
+		return c.last
+	}
+
+	// The report outlives the request that asked for it, so a client
+	// going away must not fail the check; timeout bounds it instead.
+	ctx = context.WithoutCancel(ctx)
+	results := make([]Result, len(c.deps))
+	var wg sync.WaitGroup
+	for i, d := range c.deps {
+		wg.Add(1)
+		go func(i int, d Dependency) {
+			defer wg.Done()
+			results[i] = c.check(ctx, d)
+		}(i, d)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Dependencies: results}
+	for _, r := range results {
+		if r.Status == StatusUnavailable || r.Status == StatusStarting && report.Status == StatusOK {
+			report.Status = r.Status
+		}
+	}
+	if c.last.Status != report.Status {
+		level := slog.LevelInfo
+		if report.Status == StatusUnavailable {
+			level = slog.LevelWarn
+		}
+		slog.Log(ctx, level, "readiness changed", "from", c.last.Status, "to", report.Status, "dependencies", report.Dependencies)
+	}
+	c.last, c.checkedAt = report, c.now()
+	return report
+}
+
+func (c *Checker) check(ctx context.Context, d Dependency) Result {
+	res := Result{Name: d.Name, Status: StatusOK}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := c.now()
+	resp, err := healthpb.NewHealthClient(d.Conn).Check(ctx, &healthpb.HealthCheckRequest{Service: d.Service})
+	res.LatencyMS = float64(c.now().Sub(start).Microseconds()) / 1000
+	// Idle connections only connect when used, so the state is read after
+	// the check has used it.
+	state := d.Conn.GetState()
+	res.State = state.String()
+	switch {
+	case status.Code(err) == codes.Unimplemented:
+		if state != connectivity.Ready {
+			res.Status, res.Error = c.failed(), "connection is "+state.String()
+		}
+	case err != nil:
+		res.Status, res.Error = c.failed(), err.Error()
+	default:
+		res.Health = resp.GetStatus().String()
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			res.Status, res.Error = c.failed(), "backend reports "+res.Health
+		}
+	}
+	return res
+}
+
+// failed is the status of a dependency that failed its check.
+func (c *Checker) failed() Status {
+	if c.now().Sub(c.started) < c.grace {
+		return StatusStarting
+	}
+	return StatusUnavailable
+}
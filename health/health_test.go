@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// countingServer is a health server that counts the checks it answers.
+type countingServer struct {
+	*grpchealth.Server
+	checks atomic.Int64
+}
+
+func (s *countingServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	s.checks.Add(1)
+	return s.Server.Check(ctx, req)
+}
+
+// newTestChecker returns a checker of one backend served in memory, past
+// its startup grace.
+func newTestChecker(t *testing.T, maxAge time.Duration) (*Checker, *countingServer, *testClock) {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	hs := &countingServer{Server: grpchealth.NewServer()}
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///user",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	c := NewChecker([]Dependency{{Name: "user", Conn: conn}}, time.Minute, time.Second, maxAge)
+	c.started, c.now = clock.now().Add(-time.Hour), clock.now
+	return c, hs, clock
+}
+
+func TestCheckReusesReport(t *testing.T) {
+	c, hs, clock := newTestChecker(t, time.Second)
+	for range 3 {
+		if r := c.Check(context.Background()); r.Status != StatusOK {
+			t.Fatalf("status %s, want ok", r.Status)
+		}
+	}
+	if n := hs.checks.Load(); n != 1 {
+		t.Errorf("backend checked %d times within max age, want 1", n)
+	}
+
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	clock.advance(time.Second - time.Millisecond)
+	if r := c.Check(context.Background()); r.Status != StatusOK {
+		t.Errorf("status %s before max age, want the reused ok", r.Status)
+	}
+	clock.advance(time.Millisecond)
+	if r := c.Check(context.Background()); r.Status != StatusUnavailable {
+		t.Errorf("status %s at max age, want unavailable", r.Status)
+	}
+	if n := hs.checks.Load(); n != 2 {
+		t.Errorf("backend checked %d times, want 2", n)
+	}
+}
+
+func TestCheckWithoutMaxAge(t *testing.T) {
+	c, hs, _ := newTestChecker(t, 0)
+	for range 3 {
+		c.Check(context.Background())
+	}
+	if n := hs.checks.Load(); n != 3 {
+		t.Errorf("backend checked %d times, want every time", n)
+	}
+}
+
+func TestCheckOutlivesCaller(t *testing.T) {
+	c, _, _ := newTestChecker(t, time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := c.Check(ctx); r.Status != StatusOK {
+		t.Errorf("status %s for a caller that went away, want ok", r.Status)
+	}
+}
+
+func TestPublicDropsErrors(t *testing.T) {
+	c, hs, _ := newTestChecker(t, 0)
+	hs.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	r := c.Check(context.Background())
+	if r.Dependencies[0].Error == "" {
+		t.Fatal("failed check has no error")
+	}
+	if p := r.Public(); p.Dependencies[0].Error != "" || p.Dependencies[0].Status != StatusUnavailable {
+		t.Errorf("public result %+v, want the status without the error", p.Dependencies[0])
+	}
+	if r.Dependencies[0].Error == "" {
+		t.Error("Public changed the report it was called on")
+	}
+}
+
+func TestStopSkipsCheck(t *testing.T) {
+	c, hs, _ := newTestChecker(t, time.Second)
+	c.Stop()
+	if r := c.Check(context.Background()); r.Status != StatusStopping {
+		t.Errorf("status %s after Stop, want stopping", r.Status)
+	}
+	if n := hs.checks.Load(); n != 0 {
+		t.Errorf("backend checked %d times after Stop, want 0", n)
+	}
+}
@@ -2,17 +2,133 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/requestid"
+	"github.com/InstaUpload/gateway/validate"
 )
 
+// maxRequestBody bounds the JSON bodies handlers decode.
+const maxRequestBody = 64 << 10
+
+// decodeJSON decodes the body of r into dst, a request DTO, and checks its
+// validate tags. Unknown fields, data after the object and bodies over
+// maxRequestBody are rejected. On failure the problem response is sent,
+// listing every invalid field, and false is returned.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(dst)
+	if err == nil && dec.More() {
+		err = errors.New("unexpected data after the JSON object")
+	}
+	var (
+		tooLarge *http.MaxBytesError
+		typeErr  *json.UnmarshalTypeError
+	)
+	switch {
+	case err == nil:
+	case errors.As(err, &tooLarge):
+		SendProblemResponse(w, r, ErrCodePayloadTooLarge, fmt.Sprintf("Body must not exceed %d bytes", tooLarge.Limit))
+		return false
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{
+			Field:       typeErr.Field,
+			Description: typeErr.Field + " must be " + jsonType(typeErr.Type),
+		})
+		return false
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no error type for unknown fields.
+		name := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{Field: name, Description: name + " is not a known field"})
+		return false
+	default:
+		SendProblemResponse(w, r, ErrCodeInvalidPayload, "")
+		slog.InfoContext(r.Context(), "error decoding request", logging.HTTP, "err", err)
+		return false
+	}
+	if errs := validate.Struct(dst); len(errs) > 0 {
+		fields := make([]FieldError, len(errs))
+		for i, e := range errs {
+			fields[i] = FieldError{Field: e.Field, Description: e.Description}
+		}
+		SendProblemResponse(w, r, ErrCodeValidation, "", fields...)
+		return false
+	}
+	return true
+}
+
+// jsonType names the JSON type that decodes into t.
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
+
 func SendJsonResponse(w http.ResponseWriter, statusCode int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	// NOTE: Is this not working I don't see any message when I call the create api.
 	if err := json.NewEncoder(w).Encode(data); err != nil {
 		http.Error(w, "Failed to send response", http.StatusInternalServerError)
-		log.Println("error sending response: ", err)
+		slog.Error("error sending response", logging.HTTP, "err", err)
 		return
 	}
 }
+
+// ProblemDetails is an RFC 7807 error body. Code is stable and meant for
+// programs, Title and Detail are meant for humans.
+type ProblemDetails struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      ErrorCode    `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+const problemTypePrefix = "urn:instaupload:problem:"
+
+// SendProblemResponse writes an application/problem+json response for code.
+// detail explains this occurrence and is omitted when empty.
+func SendProblemResponse(w http.ResponseWriter, r *http.Request, code ErrorCode, detail string, fields ...FieldError) {
+	spec, ok := errorCatalog[code]
+	if !ok {
+		slog.ErrorContext(r.Context(), "error code missing from catalog", logging.HTTP, "code", code)
+		code, spec = ErrCodeInternal, errorCatalog[ErrCodeInternal]
+	}
+	problem := ProblemDetails{
+		Type:      problemTypePrefix + string(code),
+		Title:     spec.Title,
+		Status:    spec.Status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      code,
+		RequestID: requestid.FromContext(r.Context()),
+		Errors:    fields,
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(spec.Status)
+	if err := json.NewEncoder(w).Encode(problem); err != nil {
+		slog.ErrorContext(r.Context(), "error sending problem response", logging.HTTP, "err", err)
+	}
+}
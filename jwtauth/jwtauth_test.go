@@ -0,0 +1,299 @@
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/InstaUpload/gateway/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+var b64 = base64.RawURLEncoding.EncodeToString
+
+// testKey is a signing key and its JWK.
+type testKey struct {
+	kid    string
+	method jwt.SigningMethod
+	priv   crypto.Signer
+	jwk    map[string]string
+}
+
+func newECKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid, jwt.SigningMethodES256, priv, map[string]string{
+		"kty": "EC", "crv": "P-256", "kid": kid,
+		"x": b64(priv.X.FillBytes(make([]byte, 32))), "y": b64(priv.Y.FillBytes(make([]byte, 32))),
+	}}
+}
+
+func newEdKey(t *testing.T, kid string) testKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testKey{kid, jwt.SigningMethodEdDSA, priv, map[string]string{
+		"kty": "OKP", "crv": "Ed25519", "kid": kid, "x": b64(pub),
+	}}
+}
+
+func jwks(t *testing.T, keys ...testKey) []byte {
+	t.Helper()
+	doc := struct {
+		Keys []map[string]string `json:"keys"`
+	}{Keys: []map[string]string{}}
+	for _, k := range keys {
+		doc.Keys = append(doc.Keys, k.jwk)
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sign returns a token of claims signed with key by method, naming kid
+// unless it is empty.
+func sign(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tok.Header["kid"] = kid
+	}
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"sub":         "42",
+		"name":        "Jo Doe",
+		"role":        "creator",
+		"is_verified": true,
+		"iat":         now.Unix(),
+		"exp":         now.Add(time.Hour).Unix(),
+	}
+}
+
+// source serves a JWKS document that tests can swap, counting loads.
+type source struct {
+	doc   atomic.Value
+	loads atomic.Int64
+}
+
+func newSource(doc []byte) *source {
+	s := &source{}
+	s.doc.Store(doc)
+	return s
+}
+
+func (s *source) load(context.Context) ([]byte, error) {
+	s.loads.Add(1)
+	return s.doc.Load().([]byte), nil
+}
+
+// newTestVerifier returns a verifier with the default settings whose key
+// set was loaded once from src.
+func newTestVerifier(t *testing.T, src *source) (*Verifier, *KeySet, *testClock) {
+	t.Helper()
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	keys := NewKeySet(src.load, time.Second)
+	keys.now = clock.now
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return NewVerifier(keys, config.Default().Auth.JWT), keys, clock
+}
+
+func TestVerify(t *testing.T) {
+	ec, ed := newECKey(t, "ec"), newEdKey(t, "ed")
+	other := newECKey(t, "ec")
+	v, _, _ := newTestVerifier(t, newSource(jwks(t, ec, ed)))
+
+	without := func(name string) jwt.MapClaims {
+		c := validClaims()
+		delete(c, name)
+		return c
+	}
+	with := func(name string, value any) jwt.MapClaims {
+		c := validClaims()
+		c[name] = value
+		return c
+	}
+	ecPub := ec.priv.Public().(*ecdsa.PublicKey)
+	ecPubBytes, _ := ecPub.ECDH()
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"es256", sign(t, ec.method, ec.priv, "ec", validClaims()), nil},
+		{"eddsa", sign(t, ed.method, ed.priv, "ed", validClaims()), nil},
+		{"bad signature", sign(t, other.method, other.priv, "ec", validClaims()), ErrInvalid},
+		{"alg none", sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, "ec", validClaims()), ErrInvalid},
+		{"alg not allowed", sign(t, jwt.SigningMethodHS256, ecPubBytes.Bytes(), "ec", validClaims()), ErrInvalid},
+		{"alg of another key type", sign(t, ec.method, ec.priv, "ed", validClaims()), ErrInvalid},
+		{"missing exp", sign(t, ec.method, ec.priv, "ec", without("exp")), ErrInvalid},
+		{"expired", sign(t, ec.method, ec.priv, "ec", with("exp", time.Now().Add(-time.Hour).Unix())), ErrExpired},
+		{"missing iat", sign(t, ec.method, ec.priv, "ec", without("iat")), ErrInvalid},
+		{"future iat", sign(t, ec.method, ec.priv, "ec", with("iat", time.Now().Add(time.Hour).Unix())), ErrInvalid},
+		{"missing sub", sign(t, ec.method, ec.priv, "ec", without("sub")), ErrInvalid},
+		{"opaque", "c3f1a9d0e2", ErrNotJWT},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tc.token)
+			if !errors.Is(err, tc.want) || (err != nil) != (tc.want != nil) {
+				t.Fatalf("error %v, want %v", err, tc.want)
+			}
+			if err == nil && (claims.User.Id != 42 || claims.User.Role != "creator" || !claims.User.IsVerified || claims.IssuedAt.IsZero()) {
+				t.Errorf("claims %+v, want user 42 with its role and iat", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutKeys(t *testing.T) {
+	ec := newECKey(t, "ec")
+	v := NewVerifier(NewKeySet(newSource(jwks(t, ec)).load, time.Second), config.Default().Auth.JWT)
+	if _, err := v.Verify(context.Background(), sign(t, ec.method, ec.priv, "ec", validClaims())); !errors.Is(err, ErrNoKeys) {
+		t.Errorf("error %v before a key set was loaded, want ErrNoKeys", err)
+	}
+}
+
+func TestUnknownKeyRefreshes(t *testing.T) {
+	old, rotated := newECKey(t, "old"), newECKey(t, "new")
+	src := newSource(jwks(t, old))
+	v, _, clock := newTestVerifier(t, src)
+	clock.advance(minRefreshInterval)
+
+	// The user service rotates in a key the gateway has not loaded yet.
+	src.doc.Store(jwks(t, old, rotated))
+	if _, err := v.Verify(context.Background(), sign(t, rotated.method, rotated.priv, "new", validClaims())); err != nil {
+		t.Fatalf("token of a rotated in key: %v", err)
+	}
+	if n := src.loads.Load(); n != 2 {
+		t.Fatalf("%d loads, want one refresh for the unknown kid", n)
+	}
+
+	// Unknown kids refresh at most once every minRefreshInterval.
+	unknown := newECKey(t, "unknown")
+	token := sign(t, unknown.method, unknown.priv, "unknown", validClaims())
+	for range 3 {
+		if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrUnknownKey) {
+			t.Fatalf("error %v, want ErrUnknownKey", err)
+		}
+	}
+	if n := src.loads.Load(); n != 2 {
+		t.Errorf("%d loads within the refresh interval, want 2", n)
+	}
+	clock.advance(minRefreshInterval)
+	v.Verify(context.Background(), token)
+	if n := src.loads.Load(); n != 3 {
+		t.Errorf("%d loads once the interval passed, want 3", n)
+	}
+}
+
+func TestTokenWithoutKid(t *testing.T) {
+	a, b := newECKey(t, "a"), newECKey(t, "b")
+	token := sign(t, a.method, a.priv, "", validClaims())
+
+	v, _, _ := newTestVerifier(t, newSource(jwks(t, a)))
+	if _, err := v.Verify(context.Background(), token); err != nil {
+		t.Errorf("token without kid and a single key: %v", err)
+	}
+	v, _, _ = newTestVerifier(t, newSource(jwks(t, a, b)))
+	if _, err := v.Verify(context.Background(), token); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token without kid and two keys: error %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestParseJWKS(t *testing.T) {
+	ec := newECKey(t, "ec")
+	shortRSA, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offCurve := map[string]string{"kty": "EC", "crv": "P-256", "kid": "off", "x": b64(make([]byte, 32)), "y": b64(big.NewInt(7).Bytes())}
+
+	for _, tc := range []struct {
+		name string
+		doc  string
+		want int
+	}{
+		{"skips encryption and unknown keys", `{"keys":[` + string(mustJSON(t, ec.jwk)) + `,{"kty":"EC","use":"enc","kid":"e"},{"kty":"oct","kid":"h","k":"c2VjcmV0"}]}`, 1},
+		{"no keys", `{"keys":[]}`, 0},
+		{"not json", `<html>`, -1},
+		{"missing keys", `{"kid":"a"}`, -1},
+		{"short rsa modulus", `{"keys":[{"kty":"RSA","kid":"r","n":"` + b64(shortRSA.N.Bytes()) + `","e":"AQAB"}]}`, -1},
+		{"bad rsa exponent", `{"keys":[{"kty":"RSA","kid":"r","n":"AQAB","e":""}]}`, -1},
+		{"point off the curve", `{"keys":[` + string(mustJSON(t, offCurve)) + `]}`, -1},
+		{"unknown curve", `{"keys":[{"kty":"EC","crv":"P-192","kid":"c","x":"AA","y":"AA"}]}`, -1},
+		{"short ed25519 key", `{"keys":[{"kty":"OKP","crv":"Ed25519","kid":"o","x":"AAAA"}]}`, -1},
+		{"duplicate kid", `{"keys":[` + string(mustJSON(t, ec.jwk)) + `,` + string(mustJSON(t, ec.jwk)) + `]}`, -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := parseJWKS([]byte(tc.doc))
+			switch {
+			case tc.want < 0 && err == nil:
+				t.Errorf("parsed %d keys, want an error", len(keys))
+			case tc.want >= 0 && err != nil:
+				t.Errorf("error %v", err)
+			case tc.want >= 0 && len(keys) != tc.want:
+				t.Errorf("parsed %d keys, want %d", len(keys), tc.want)
+			}
+		})
+	}
+}
+
+func TestRefreshKeepsKeysOnError(t *testing.T) {
+	ec := newECKey(t, "ec")
+	src := newSource(jwks(t, ec))
+	v, keys, _ := newTestVerifier(t, src)
+
+	src.doc.Store([]byte(`{"keys":[{"kty":"RSA","kid":"broken"}]}`))
+	if err := keys.Refresh(context.Background()); err == nil {
+		t.Fatal("refresh with a malformed document succeeded")
+	}
+	if keys.Len() != 1 {
+		t.Fatalf("%d keys after a failed refresh, want the previous 1", keys.Len())
+	}
+	if _, err := v.Verify(context.Background(), sign(t, ec.method, ec.priv, "ec", validClaims())); err != nil {
+		t.Errorf("token of a previous key after a failed refresh: %v", err)
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,302 @@
+// Package jwtauth verifies JWTs issued by the user service locally, against
+// the keys of a JWKS document, so authenticated requests do not need an
+// AuthUser call.
+//
+// The JWKS is read from a URL or a file and refreshed periodically. A token
+// signed with a key the gateway has not seen yet triggers an early refresh,
+// so keys rotated in by the user service are picked up without waiting for
+// the next interval. The last good key set is kept when a refresh fails.
+package jwtauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// minRefreshInterval bounds how often unknown key IDs can force a refresh.
+const minRefreshInterval = 30 * time.Second
+
+// maxJWKSSize bounds the JWKS document read from a URL.
+const maxJWKSSize = 1 << 20
+
+// Source reads the raw JWKS document.
+type Source func(ctx context.Context) ([]byte, error)
+
+// URLSource fetches the JWKS over http.
+func URLSource(client *http.Client, url string) Source {
+	return func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching %s: unexpected status %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+	}
+}
+
+// FileSource reads the JWKS from a file, which is re-read on every refresh.
+func FileSource(path string) Source {
+	return func(context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// KeySet holds the verification keys of a JWKS by key ID.
+type KeySet struct {
+	source  Source
+	timeout time.Duration
+	group   singleflight.Group
+	now     func() time.Time
+
+	mu          sync.RWMutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+	lastAttempt time.Time
+}
+
+func NewKeySet(source Source, timeout time.Duration) *KeySet {
+	return &KeySet{
+		source:  source,
+		timeout: timeout,
+		now:     time.Now,
+	}
+}
+
+// Refresh reloads the key set. Concurrent calls share one load. On error
+// the current keys are kept.
+func (s *KeySet) Refresh(ctx context.Context) error {
+	_, err, _ := s.group.Do("refresh", func() (any, error) {
+		s.mu.Lock()
+		s.lastAttempt = s.now()
+		s.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.timeout)
+		defer cancel()
+		data, err := s.source(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("loading jwks: %w", err)
+		}
+		keys, err := parseJWKS(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing jwks: %w", err)
+		}
+		s.mu.Lock()
+		s.keys = keys
+		s.loadedAt = s.now()
+		s.mu.Unlock()
+		return nil, nil
+	})
+	return err
+}
+
+// Run refreshes the key set every interval until ctx is done.
+func (s *KeySet) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Refresh(ctx); err != nil {
+				slog.Error("error refreshing jwks, keeping previous keys", "err", err)
+			}
+		}
+	}
+}
+
+// Loaded reports whether a key set was ever loaded.
+func (s *KeySet) Loaded() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys != nil
+}
+
+// LoadedAt returns when the key set was last loaded successfully.
+func (s *KeySet) LoadedAt() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.loadedAt
+}
+
+// Len returns the number of usable keys.
+func (s *KeySet) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.keys)
+}
+
+// key returns the key with the given ID. A token without a kid is accepted
+// only when the set holds a single key. Unknown IDs refresh the set, at most
+// once every minRefreshInterval, before giving up.
+func (s *KeySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if k, ok := s.lookup(kid); ok {
+		return k, nil
+	}
+	s.mu.RLock()
+	stale := s.now().Sub(s.lastAttempt) >= minRefreshInterval
+	s.mu.RUnlock()
+	if stale {
+		if err := s.Refresh(ctx); err != nil {
+			slog.ErrorContext(ctx, "error refreshing jwks for unknown key", "kid", kid, "err", err)
+		}
+		if k, ok := s.lookup(kid); ok {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownKey, kid)
+}
+
+func (s *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if kid == "" {
+		if len(s.keys) == 1 {
+			for _, k := range s.keys {
+				return k, true
+			}
+		}
+		return nil, false
+	}
+	k, ok := s.keys[kid]
+	return k, ok
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the signing keys of a JWKS document. Keys meant for
+// encryption and keys of unsupported types are skipped; a malformed key of
+// a supported type fails the whole document so a broken rotation is not
+// half applied.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var doc struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Keys == nil {
+		return nil, errors.New(`missing "keys"`)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for i, raw := range doc.Keys {
+		var k jwk
+		if err := json.Unmarshal(raw, &k); err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var pub crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			pub, err = rsaKey(k)
+		case "EC":
+			pub, err = ecKey(k)
+		case "OKP":
+			pub, err = okpKey(k)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %d (kid %q): %w", i, k.Kid, err)
+		}
+		if _, dup := keys[k.Kid]; dup {
+			return nil, fmt.Errorf("key %d: duplicate kid %q", i, k.Kid)
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
+
+func rsaKey(k jwk) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil || len(n) == 0 {
+		return nil, errors.New("invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+	pub := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+	if pub.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("modulus of %d bits is too short", pub.N.BitLen())
+	}
+	return pub, nil
+}
+
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func ecKey(k jwk) (*ecdsa.PublicKey, error) {
+	curve, ok := curves[k.Crv]
+	if !ok {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(k.X)
+	y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+	if errX != nil || errY != nil {
+		return nil, errors.New("invalid coordinates")
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if _, err := pub.ECDH(); err != nil {
+		return nil, errors.New("point is not on the curve")
+	}
+	return pub, nil
+}
+
+func okpKey(k jwk) (ed25519.PublicKey, error) {
+	if k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil || len(x) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key")
+	}
+	return ed25519.PublicKey(x), nil
+}
@@ -0,0 +1,183 @@
+package jwtauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "github.com/InstaUpload/common/api"
+	"github.com/InstaUpload/gateway/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	// ErrNotJWT is returned for opaque tokens, which have to be checked
+	// with the user service instead.
+	ErrNotJWT = errors.New("jwtauth: not a jwt")
+	// ErrNoKeys is returned while no key set could be loaded yet.
+	ErrNoKeys     = errors.New("jwtauth: no key set loaded")
+	ErrUnknownKey = errors.New("jwtauth: unknown key id")
+	ErrExpired    = errors.New("jwtauth: token expired")
+	ErrInvalid    = errors.New("jwtauth: invalid token")
+)
+
+// Claims is what a verified token says about its user.
+type Claims struct {
+	User      *pb.AuthUserResponse
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	// Values holds every claim whose value is a string, number or boolean,
+	// or a list of them, as strings, for authorization rules to match on.
+	Values map[string][]string
+}
+
+type Verifier struct {
+	keys   *KeySet
+	cfg    config.JWTConfig
+	parser *jwt.Parser
+}
+
+func NewVerifier(keys *KeySet, cfg config.JWTConfig) *Verifier {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(cfg.Algorithms),
+		jwt.WithLeeway(cfg.Leeway),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+	if cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Audience))
+	}
+	return &Verifier{keys: keys, cfg: cfg, parser: jwt.NewParser(opts...)}
+}
+
+// Verify checks the signature and registered claims of token and reads the
+// user from it. It returns ErrNotJWT or ErrNoKeys when the token can not be
+// checked locally.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	if !looksLikeJWT(token) {
+		return Claims{}, ErrNotJWT
+	}
+	if !v.keys.Loaded() {
+		return Claims{}, ErrNoKeys
+	}
+	var claims jwt.MapClaims
+	_, err := v.parser.ParseWithClaims(token, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.key(ctx, kid)
+	})
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return Claims{}, ErrExpired
+	case err != nil:
+		return Claims{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	return v.claims(claims)
+}
+
+func (v *Verifier) claims(mc jwt.MapClaims) (Claims, error) {
+	names := v.cfg.Claims
+	id, err := userID(mc[names.UserID])
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: claim %q: %w", ErrInvalid, names.UserID, err)
+	}
+	user := &pb.AuthUserResponse{Id: id}
+	user.Name, _ = mc[names.Name].(string)
+	user.Email, _ = mc[names.Email].(string)
+	user.Role, _ = mc[names.Role].(string)
+	user.IsVerified, _ = mc[names.Verified].(bool)
+
+	// Logging out of all sessions cuts off a user's tokens by iat, so a
+	// token without one could not be told from those issued later.
+	iat, _ := mc.GetIssuedAt()
+	if iat == nil {
+		return Claims{}, fmt.Errorf("%w: missing iat", ErrInvalid)
+	}
+	c := Claims{User: user, IssuedAt: iat.Time, Values: claimValues(mc)}
+	if exp, err := mc.GetExpirationTime(); err == nil && exp != nil {
+		c.ExpiresAt = exp.Time
+	}
+	return c, nil
+}
+
+// claimValues returns the claims of mc that are scalars or lists of
+// scalars as strings. Objects, and lists holding any, are left out.
+func claimValues(mc jwt.MapClaims) map[string][]string {
+	values := make(map[string][]string, len(mc))
+next:
+	for name, v := range mc {
+		list, ok := v.([]any)
+		if !ok {
+			list = []any{v}
+		}
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := scalarString(item)
+			if !ok {
+				continue next
+			}
+			strs = append(strs, s)
+		}
+		values[name] = strs
+	}
+	return values
+}
+
+func scalarString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// userID accepts the user ID as a JSON number or, as sub usually is, a
+// decimal string.
+func userID(v any) (int64, error) {
+	switch id := v.(type) {
+	case float64:
+		if id != math.Trunc(id) || id <= 0 || id > math.MaxInt64 {
+			return 0, fmt.Errorf("%v is not a user id", id)
+		}
+		return int64(id), nil
+	case string:
+		n, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("%q is not a user id", id)
+		}
+		return n, nil
+	case nil:
+		return 0, errors.New("missing")
+	default:
+		return 0, fmt.Errorf("unexpected type %T", v)
+	}
+}
+
+// looksLikeJWT reports whether token is a compact JWS whose header names an
+// algorithm. Anything else is treated as an opaque token.
+func looksLikeJWT(token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(raw, &header) == nil && header.Alg != ""
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/InstaUpload/gateway/lockout"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/ratelimit"
+)
+
+// LockoutsResponse lists the emails and addresses that can not log in
+// right now.
+type LockoutsResponse struct {
+	Lockouts []lockout.Status `json:"lockouts"`
+}
+
+// loginKeys returns the keys a login attempt for email is counted under.
+func (h *Handler) loginKeys(r *http.Request, email string) map[lockout.Kind]string {
+	return map[lockout.Kind]string{
+		lockout.KindEmail: email,
+		lockout.KindIP:    ratelimit.ClientIP(r, h.trustedProxies),
+	}
+}
+
+// checkLoginLockout refuses the attempt with 429 when any of keys is
+// locked, still waiting out the delay after its last failure, or has too
+// many attempts in flight. The response is the same in every case, so it
+// does not tell whether the account or the address is being throttled.
+// The attempt it lets through is reserved until it is settled; it is nil
+// when lockouts are off.
+func (h *Handler) checkLoginLockout(w http.ResponseWriter, r *http.Request, keys map[lockout.Kind]string) (*lockout.Attempt, bool) {
+	if h.lockout == nil {
+		return nil, true
+	}
+	d := h.lockout.Check(keys)
+	if d.Allowed {
+		return d.Attempt, true
+	}
+	w.Header().Set("Retry-After", ceilSeconds(d.RetryAfter))
+	SendProblemResponse(w, r, ErrCodeLoginLocked, "Too many failed logins, try again later")
+	return nil, false
+}
+
+// recordLoginFailure counts a rejected password against the keys of
+// attempt and logs the keys it locks.
+func (h *Handler) recordLoginFailure(ctx context.Context, attempt *lockout.Attempt) {
+	now := time.Now()
+	for _, s := range attempt.Fail() {
+		// A lock starts the failure count over.
+		if s.Locked(now) && s.Failures == 0 {
+			// Keys are logged under their kind, so emails are masked.
+			slog.WarnContext(ctx, "login locked", logging.Auth, string(s.Kind), s.Key, "locked_until", s.LockedUntil, "locks", s.Locks)
+		}
+	}
+}
+
+// recordLoginSuccess settles attempt and forgets the failures of the
+// email. Failures of the address are kept, so one valid account does not
+// clear an address that is trying many others.
+func (h *Handler) recordLoginSuccess(attempt *lockout.Attempt, keys map[lockout.Kind]string) {
+	attempt.Release()
+	if h.lockout != nil {
+		h.lockout.Succeed(lockout.KindEmail, keys[lockout.KindEmail])
+	}
+}
+
+// lockoutKey reads the email or ip query parameter. Exactly one of them
+// must be given.
+func lockoutKey(w http.ResponseWriter, r *http.Request) (lockout.Kind, string, bool) {
+	email, ip := r.URL.Query().Get("email"), r.URL.Query().Get("ip")
+	switch {
+	case email != "" && ip == "":
+		return lockout.KindEmail, email, true
+	case ip != "" && email == "":
+		return lockout.KindIP, ip, true
+	}
+	SendProblemResponse(w, r, ErrCodeMissingParameter, "Exactly one of email and ip is required")
+	return "", "", false
+}
+
+// ListLockouts godoc
+//
+//	@Summary		List Login Lockouts
+//	@Description	List the emails and addresses that are locked out or waiting out the delay after a failed login, most recent failure first. Requires the lockouts:manage permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			kind	query		string	false	"Only list keys of this kind"	Enums(email, ip)
+//	@Success		200		{object}	LockoutsResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		403		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Security		ApiKeyAuth
+//	@Router			/v1/admin/lockouts [get]
+func (h *Handler) ListLockouts(w http.ResponseWriter, r *http.Request) {
+	kind := lockout.Kind(r.URL.Query().Get("kind"))
+	if kind != "" && kind != lockout.KindEmail && kind != lockout.KindIP {
+		SendProblemResponse(w, r, ErrCodeMissingParameter, "kind must be email or ip")
+		return
+	}
+	SendJsonResponse(w, http.StatusOK, LockoutsResponse{Lockouts: h.lockout.Blocked(kind)})
+}
+
+// GetLockout godoc
+//
+//	@Summary		Get Login Lockout
+//	@Description	Show the failed logins recorded for an email or address. Requires the lockouts:manage permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			email	query		string	false	"Email, exclusive with ip"
+//	@Param			ip		query		string	false	"Client address, exclusive with email"
+//	@Success		200		{object}	lockout.Status
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		403		{object}	ProblemDetails
+//	@Failure		404		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Security		ApiKeyAuth
+//	@Router			/v1/admin/lockouts/status [get]
+func (h *Handler) GetLockout(w http.ResponseWriter, r *http.Request) {
+	kind, key, ok := lockoutKey(w, r)
+	if !ok {
+		return
+	}
+	status, ok := h.lockout.Status(kind, key)
+	if !ok {
+		SendProblemResponse(w, r, ErrCodeNotFound, "No failed logins recorded")
+		return
+	}
+	SendJsonResponse(w, http.StatusOK, status)
+}
+
+// DeleteLockout godoc
+//
+//	@Summary		Clear Login Lockout
+//	@Description	Forget the failed logins of an email or address, lifting its lock and delay. Requires the lockouts:manage permission.
+//	@Tags			Admin
+//	@Produce		json
+//	@Param			email	query		string	false	"Email, exclusive with ip"
+//	@Param			ip		query		string	false	"Client address, exclusive with email"
+//	@Success		200		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		403		{object}	ProblemDetails
+//	@Failure		404		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Security		ApiKeyAuth
+//	@Router			/v1/admin/lockouts [delete]
+func (h *Handler) DeleteLockout(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+	kind, key, ok := lockoutKey(w, r)
+	if !ok {
+		return
+	}
+	if !h.lockout.Unlock(kind, key) {
+		SendProblemResponse(w, r, ErrCodeNotFound, "No failed logins recorded")
+		return
+	}
+	slog.InfoContext(r.Context(), "login lockout cleared", logging.Auth, string(kind), lockout.Normalize(kind, key), "by_user_id", user.UserID)
+	SendJsonResponse(w, http.StatusOK, MessageResponse{Message: "Cleared login lockout successfully"})
+}
@@ -0,0 +1,361 @@
+// Package lockout slows down and then stops repeated failed logins.
+//
+// Failures are counted per key, an email address or a client address.
+// Every failure makes the key wait before its next attempt, doubling from
+// BaseDelay up to MaxDelay. Reaching Threshold failures locks the key for
+// LockDuration, doubling with every further lock up to MaxLockDuration.
+// Failures are forgotten after Window without one, locks once
+// MaxLockDuration has passed since the last one ended, and both on a
+// successful login.
+//
+// Check reserves every attempt it lets through until it is settled, so
+// attempts still waiting for the backend count too: a parallel burst can
+// not make more attempts than Threshold allows, and once a key with a
+// delay has failed its attempts go one at a time.
+package lockout
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind tells what a key is.
+type Kind string
+
+const (
+	KindEmail Kind = "email"
+	KindIP    Kind = "ip"
+)
+
+// Policy configures one kind of key.
+type Policy struct {
+	Threshold       int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockDuration    time.Duration
+	MaxLockDuration time.Duration
+	Window          time.Duration
+}
+
+// Status is what the tracker knows about a key.
+type Status struct {
+	Kind        Kind      `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int       `json:"failures"`
+	Locks       int       `json:"locks"`
+	LastFailure time.Time `json:"last_failure"`
+	// InFlight counts the attempts let through and not settled yet.
+	InFlight int `json:"in_flight"`
+	// NextAttempt is when the key may try again after its last failure.
+	NextAttempt time.Time `json:"next_attempt"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// Locked reports whether the key is locked at now.
+func (s Status) Locked(now time.Time) bool {
+	return now.Before(s.LockedUntil)
+}
+
+// Decision says whether a login attempt may go ahead.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	// Blocked is the status of the key that refused the attempt.
+	Blocked Status
+	// Attempt is the reservation of an allowed attempt.
+	Attempt *Attempt
+}
+
+// Attempt is a login attempt Check let through. It counts against its
+// keys until it is settled with Fail or Release; whichever comes first
+// settles it, later calls do nothing. Its methods may be called on a nil
+// Attempt.
+type Attempt struct {
+	t       *Tracker
+	keys    map[Kind]string
+	settled bool
+}
+
+// Fail records the attempt as failed for every key and returns their
+// statuses afterwards.
+func (a *Attempt) Fail() []Status {
+	if a == nil {
+		return nil
+	}
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	if a.settled {
+		return nil
+	}
+	a.settled = true
+	a.t.releaseLocked(a.keys)
+	return a.t.failLocked(a.keys)
+}
+
+// Release settles the attempt without counting it, for attempts that
+// succeeded or never reached a verdict.
+func (a *Attempt) Release() {
+	if a == nil {
+		return
+	}
+	a.t.mu.Lock()
+	defer a.t.mu.Unlock()
+	if a.settled {
+		return
+	}
+	a.settled = true
+	a.t.releaseLocked(a.keys)
+}
+
+// Tracker keeps the failures of every key in process memory, so every
+// replica counts on its own.
+type Tracker struct {
+	mu         sync.Mutex
+	policies   map[Kind]Policy
+	entries    map[string]*Status
+	maxEntries int
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// sweepInterval bounds how often writes scan for forgotten keys.
+const sweepInterval = time.Minute
+
+// New tracks at most maxEntries keys. Kinds without a policy are not
+// tracked.
+func New(policies map[Kind]Policy, maxEntries int) *Tracker {
+	return &Tracker{
+		policies:   policies,
+		entries:    map[string]*Status{},
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// Normalize returns the form keys of kind are stored under.
+func Normalize(kind Kind, key string) string {
+	key = strings.TrimSpace(key)
+	if kind == KindEmail {
+		key = strings.ToLower(key)
+	}
+	return key
+}
+
+func entryKey(kind Kind, key string) string {
+	return string(kind) + ":" + Normalize(kind, key)
+}
+
+// Check decides whether an attempt by every given key may go ahead. keys
+// maps each kind to its key; the longest wait among them is returned.
+// Attempts in flight count as failures towards Threshold, and a key that
+// has failed lets only one attempt through at a time if it has a delay to
+// wait out. An allowed attempt is reserved until its Attempt is settled.
+func (t *Tracker) Check(keys map[Kind]string) Decision {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	d := Decision{Allowed: true}
+	for kind, key := range keys {
+		p, ok := t.policies[kind]
+		if !ok || key == "" {
+			continue
+		}
+		s, ok := t.entries[entryKey(kind, key)]
+		if !ok {
+			continue
+		}
+		failures := s.Failures
+		if now.Sub(s.LastFailure) > p.Window {
+			failures = 0
+		}
+		var wait time.Duration
+		switch {
+		case s.Locked(now):
+			wait = s.LockedUntil.Sub(now)
+		case now.Before(s.NextAttempt):
+			wait = s.NextAttempt.Sub(now)
+		case s.InFlight > 0 && (failures > 0 && p.BaseDelay > 0 || failures+s.InFlight >= p.Threshold):
+			// The attempts in flight may fail; wait for the delay the next
+			// failure would impose.
+			wait = backoff(p.BaseDelay, failures, p.MaxDelay)
+		default:
+			continue
+		}
+		// The wait can be zero when there is no delay configured.
+		if d.Allowed || wait > d.RetryAfter {
+			d = Decision{RetryAfter: wait, Blocked: *s}
+		}
+	}
+	if !d.Allowed {
+		return d
+	}
+	t.sweepLocked(now)
+	a := &Attempt{t: t, keys: map[Kind]string{}}
+	for kind, key := range keys {
+		if _, ok := t.policies[kind]; !ok || key == "" {
+			continue
+		}
+		t.entryLocked(kind, key).InFlight++
+		a.keys[kind] = key
+	}
+	d.Attempt = a
+	return d
+}
+
+// entryLocked returns the entry of key, adding it if it is not tracked.
+func (t *Tracker) entryLocked(kind Kind, key string) *Status {
+	k := entryKey(kind, key)
+	s, ok := t.entries[k]
+	if !ok {
+		if len(t.entries) >= t.maxEntries {
+			t.evictLocked()
+		}
+		s = &Status{Kind: kind, Key: Normalize(kind, key)}
+		t.entries[k] = s
+	}
+	return s
+}
+
+// releaseLocked ends the reservation of an attempt by keys, dropping the
+// entries that only held reservations.
+func (t *Tracker) releaseLocked(keys map[Kind]string) {
+	for kind, key := range keys {
+		k := entryKey(kind, key)
+		s, ok := t.entries[k]
+		if !ok || s.InFlight == 0 {
+			continue
+		}
+		s.InFlight--
+		if s.InFlight == 0 && s.LastFailure.IsZero() {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// failLocked records a failed attempt by every given key and returns their
+// statuses afterwards.
+func (t *Tracker) failLocked(keys map[Kind]string) []Status {
+	now := t.now()
+	var statuses []Status
+	for kind, key := range keys {
+		p := t.policies[kind]
+		s := t.entryLocked(kind, key)
+		if now.Sub(s.LockedUntil) > p.MaxLockDuration {
+			s.Locks = 0
+		}
+		if now.Sub(s.LastFailure) > p.Window {
+			s.Failures = 0
+		}
+		s.Failures++
+		s.LastFailure = now
+		s.NextAttempt = now.Add(backoff(p.BaseDelay, s.Failures-1, p.MaxDelay))
+		if s.Failures >= p.Threshold {
+			s.Locks++
+			s.LockedUntil = now.Add(backoff(p.LockDuration, s.Locks-1, p.MaxLockDuration))
+			s.Failures = 0
+		}
+		statuses = append(statuses, *s)
+	}
+	return statuses
+}
+
+// Succeed forgets key after a successful login.
+func (t *Tracker) Succeed(kind Kind, key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, entryKey(kind, key))
+}
+
+// Unlock forgets key and reports whether it was tracked.
+func (t *Tracker) Unlock(kind Kind, key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	k := entryKey(kind, key)
+	_, ok := t.entries[k]
+	delete(t.entries, k)
+	return ok
+}
+
+// Status returns what is known about key.
+func (t *Tracker) Status(kind Kind, key string) (Status, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.entries[entryKey(kind, key)]
+	if !ok {
+		return Status{}, false
+	}
+	return *s, true
+}
+
+// Blocked returns the keys of kind, or of every kind when kind is empty,
+// that are locked or waiting out a delay, most recent failure first.
+func (t *Tracker) Blocked(kind Kind) []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	list := []Status{}
+	for _, s := range t.entries {
+		if kind != "" && s.Kind != kind {
+			continue
+		}
+		if s.Locked(now) || now.Before(s.NextAttempt) {
+			list = append(list, *s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastFailure.After(list[j].LastFailure) })
+	return list
+}
+
+// Len returns the number of keys tracked.
+func (t *Tracker) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.entries)
+}
+
+// sweepLocked drops keys whose failures and locks are both forgotten.
+func (t *Tracker) sweepLocked(now time.Time) {
+	if now.Sub(t.lastSweep) < sweepInterval {
+		return
+	}
+	t.lastSweep = now
+	for k, s := range t.entries {
+		p := t.policies[s.Kind]
+		if s.InFlight == 0 && now.Sub(s.LastFailure) > p.Window && now.Sub(s.LockedUntil) > p.MaxLockDuration {
+			delete(t.entries, k)
+		}
+	}
+}
+
+// evictLocked makes room for one key, dropping the one whose last failure
+// is oldest among those neither locked nor in flight.
+func (t *Tracker) evictLocked() {
+	now := t.now()
+	oldest := ""
+	for k, s := range t.entries {
+		if s.Locked(now) || s.InFlight > 0 {
+			continue
+		}
+		if oldest == "" || s.LastFailure.Before(t.entries[oldest].LastFailure) {
+			oldest = k
+		}
+	}
+	if oldest == "" {
+		for k := range t.entries {
+			oldest = k
+			break
+		}
+	}
+	delete(t.entries, oldest)
+}
+
+// backoff returns base doubled n times, capped at limit.
+func backoff(base time.Duration, n int, limit time.Duration) time.Duration {
+	d := base
+	for i := 0; i < n && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
@@ -0,0 +1,241 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+var testPolicy = Policy{
+	Threshold:       3,
+	BaseDelay:       time.Second,
+	MaxDelay:        4 * time.Second,
+	LockDuration:    time.Minute,
+	MaxLockDuration: 4 * time.Minute,
+	Window:          10 * time.Minute,
+}
+
+var testKeys = map[Kind]string{KindEmail: "Jo@Example.com"}
+
+func newTestTracker(p Policy) (*Tracker, *testClock) {
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	t := New(map[Kind]Policy{KindEmail: p, KindIP: p}, 100)
+	t.now = clock.now
+	return t, clock
+}
+
+// fail makes an attempt that must be allowed and records it as failed.
+func fail(t *testing.T, tr *Tracker, keys map[Kind]string) Status {
+	t.Helper()
+	d := tr.Check(keys)
+	if !d.Allowed {
+		t.Fatalf("attempt refused for %s", d.RetryAfter)
+	}
+	statuses := d.Attempt.Fail()
+	if len(statuses) != 1 {
+		t.Fatalf("Fail returned %d statuses, want 1", len(statuses))
+	}
+	return statuses[0]
+}
+
+func TestDelayGrows(t *testing.T) {
+	p := testPolicy
+	p.Threshold = 10
+	tr, clock := newTestTracker(p)
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		s := fail(t, tr, testKeys)
+		if got := s.NextAttempt.Sub(clock.now()); got != want {
+			t.Errorf("failure %d: delay %s, want %s", i+1, got, want)
+		}
+		d := tr.Check(testKeys)
+		if d.Allowed || d.RetryAfter != want {
+			t.Errorf("failure %d: check allowed %v retry after %s, want refused for %s", i+1, d.Allowed, d.RetryAfter, want)
+		}
+		clock.advance(want)
+	}
+}
+
+func TestThresholdLocks(t *testing.T) {
+	tr, clock := newTestTracker(testPolicy)
+	fail(t, tr, testKeys)
+	clock.advance(time.Second)
+	s := fail(t, tr, testKeys)
+	if s.Locked(clock.now()) {
+		t.Fatal("locked below the threshold")
+	}
+	clock.advance(2 * time.Second)
+	s = fail(t, tr, testKeys)
+	if !s.Locked(clock.now()) || s.LockedUntil.Sub(clock.now()) != time.Minute {
+		t.Fatalf("at the threshold locked until %v, want a minute from now", s.LockedUntil)
+	}
+	if s.Failures != 0 || s.Locks != 1 {
+		t.Errorf("after the lock: %d failures and %d locks, want 0 and 1", s.Failures, s.Locks)
+	}
+	if s.Key != "jo@example.com" {
+		t.Errorf("key %q, want the normalized email", s.Key)
+	}
+	if d := tr.Check(testKeys); d.Allowed || d.RetryAfter != time.Minute || d.Blocked.Locks != 1 {
+		t.Errorf("check while locked: allowed %v retry after %s", d.Allowed, d.RetryAfter)
+	}
+}
+
+// lock fails until the key is locked and waits for the lock to end.
+func lock(t *testing.T, tr *Tracker, clock *testClock) time.Duration {
+	t.Helper()
+	for {
+		s := fail(t, tr, testKeys)
+		if s.Locked(clock.now()) {
+			d := s.LockedUntil.Sub(clock.now())
+			clock.advance(d)
+			return d
+		}
+		clock.advance(s.NextAttempt.Sub(clock.now()))
+	}
+}
+
+func TestLockExpiresAndEscalates(t *testing.T) {
+	tr, clock := newTestTracker(testPolicy)
+	if d := lock(t, tr, clock); d != time.Minute {
+		t.Fatalf("first lock %s, want 1m", d)
+	}
+	s := fail(t, tr, testKeys)
+	if s.Failures != 1 || s.Locked(clock.now()) {
+		t.Errorf("first failure after the lock: %d failures, locked %v; want the count started over", s.Failures, s.Locked(clock.now()))
+	}
+	clock.advance(time.Second)
+	fail(t, tr, testKeys)
+	clock.advance(2 * time.Second)
+	s = fail(t, tr, testKeys)
+	if got := s.LockedUntil.Sub(clock.now()); got != 2*time.Minute || s.Locks != 2 {
+		t.Errorf("second lock %s with %d locks, want 2m and 2", got, s.Locks)
+	}
+	clock.advance(2 * time.Minute)
+	if d := lock(t, tr, clock); d != 4*time.Minute {
+		t.Errorf("third lock %s, want 4m", d)
+	}
+	if d := lock(t, tr, clock); d != 4*time.Minute {
+		t.Errorf("fourth lock %s, want the 4m cap", d)
+	}
+
+	// Locks are forgotten once MaxLockDuration passes without one.
+	clock.advance(4*time.Minute + time.Second)
+	if d := lock(t, tr, clock); d != time.Minute {
+		t.Errorf("lock after a quiet period %s, want 1m again", d)
+	}
+}
+
+func TestFailuresForgottenAfterWindow(t *testing.T) {
+	tr, clock := newTestTracker(testPolicy)
+	fail(t, tr, testKeys)
+	clock.advance(time.Second)
+	fail(t, tr, testKeys)
+	clock.advance(testPolicy.Window + time.Second)
+	if s := fail(t, tr, testKeys); s.Failures != 1 || s.Locked(clock.now()) {
+		t.Errorf("after the window: %d failures, locked %v; want 1 and unlocked", s.Failures, s.Locked(clock.now()))
+	}
+}
+
+func TestSucceedForgets(t *testing.T) {
+	tr, _ := newTestTracker(testPolicy)
+	fail(t, tr, testKeys)
+	tr.Succeed(KindEmail, "jo@example.com ")
+	if _, ok := tr.Status(KindEmail, testKeys[KindEmail]); ok {
+		t.Error("key still tracked after a successful login")
+	}
+	if !tr.Check(testKeys).Allowed {
+		t.Error("attempt refused after a successful login")
+	}
+}
+
+func TestParallelAttemptsReserve(t *testing.T) {
+	tr, _ := newTestTracker(testPolicy)
+	var attempts []*Attempt
+	for i := range testPolicy.Threshold {
+		d := tr.Check(testKeys)
+		if !d.Allowed {
+			t.Fatalf("parallel attempt %d refused", i+1)
+		}
+		attempts = append(attempts, d.Attempt)
+	}
+	if tr.Check(testKeys).Allowed {
+		t.Fatal("more parallel attempts allowed than the threshold")
+	}
+
+	attempts[0].Release()
+	attempts[0].Release()
+	d := tr.Check(testKeys)
+	if !d.Allowed {
+		t.Fatal("attempt refused after a reservation was released")
+	}
+	attempts[0] = d.Attempt
+
+	// Attempts settled after the key failed still count, so the burst
+	// locks the key.
+	var last Status
+	for _, a := range attempts {
+		if statuses := a.Fail(); len(statuses) == 1 {
+			last = statuses[0]
+		}
+	}
+	if last.Locks != 1 {
+		t.Errorf("after a burst of %d failures: %d locks, want 1", len(attempts), last.Locks)
+	}
+	if a := attempts[0]; a.Fail() != nil {
+		t.Error("settled attempt failed again")
+	}
+}
+
+func TestFailedKeyGoesOneAtATime(t *testing.T) {
+	tr, clock := newTestTracker(testPolicy)
+	fail(t, tr, testKeys)
+	clock.advance(time.Second)
+
+	d := tr.Check(testKeys)
+	if !d.Allowed {
+		t.Fatal("attempt after the delay refused")
+	}
+	if r := tr.Check(testKeys); r.Allowed || r.RetryAfter != 2*time.Second {
+		t.Errorf("second attempt in flight: allowed %v retry after %s, want refused for the next delay", r.Allowed, r.RetryAfter)
+	}
+	d.Attempt.Release()
+	if !tr.Check(testKeys).Allowed {
+		t.Error("attempt refused after the one in flight was released")
+	}
+}
+
+func TestFailedKeyWithoutDelayGoesInParallel(t *testing.T) {
+	p := testPolicy
+	p.BaseDelay, p.MaxDelay = 0, 0
+	tr, _ := newTestTracker(p)
+	fail(t, tr, testKeys)
+
+	// One failure and one attempt in flight leave room for one more.
+	if !tr.Check(testKeys).Allowed || !tr.Check(testKeys).Allowed {
+		t.Fatal("attempts below the threshold refused")
+	}
+	if tr.Check(testKeys).Allowed {
+		t.Error("attempt allowed past the threshold")
+	}
+}
+
+func TestReleaseDropsReservedOnlyKeys(t *testing.T) {
+	tr, _ := newTestTracker(testPolicy)
+	d := tr.Check(map[Kind]string{KindEmail: "a@example.com", KindIP: "192.0.2.1"})
+	if tr.Len() != 2 {
+		t.Fatalf("tracking %d keys while in flight, want 2", tr.Len())
+	}
+	d.Attempt.Release()
+	if tr.Len() != 0 {
+		t.Errorf("tracking %d keys after release, want 0", tr.Len())
+	}
+	var nilAttempt *Attempt
+	nilAttempt.Release()
+	if nilAttempt.Fail() != nil {
+		t.Error("nil attempt returned statuses")
+	}
+}
@@ -0,0 +1,190 @@
+package logging
+
+import (
+	"log/slog"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ComponentKey is the attribute naming the component a record belongs to.
+const ComponentKey = "component"
+
+// Attributes assigning records to the components whose levels can be set
+// on their own, e.g. slog.WarnContext(ctx, "msg", logging.Auth).
+var (
+	HTTP       = slog.String(ComponentKey, "http")
+	GRPCClient = slog.String(ComponentKey, "grpc-client")
+	Auth       = slog.String(ComponentKey, "auth")
+	RateLimit  = slog.String(ComponentKey, "ratelimit")
+)
+
+// Levels holds the lowest level logged, globally and for components that
+// have a level of their own. Both can be changed at runtime, for good or
+// until a timer puts back the configured level.
+type Levels struct {
+	mu sync.RWMutex
+	// configured are the levels set with NewLevels or Reset, keyed by
+	// component; "" is the global level.
+	configured map[string]slog.Level
+	current    map[string]slog.Level
+	reverts    map[string]revert
+	// min is the lowest of current, so records below it are dropped
+	// before they are built.
+	min atomic.Int64
+}
+
+type revert struct {
+	timer *time.Timer
+	at    time.Time
+}
+
+// LevelState is the level of the global logger or a component.
+type LevelState struct {
+	Level slog.Level
+	// RevertAt is when the configured level comes back, zero if it does
+	// not.
+	RevertAt time.Time
+}
+
+// NewLevels returns levels logging at global, and at the level in
+// components for records of those components.
+func NewLevels(global slog.Level, components map[string]slog.Level) *Levels {
+	l := &Levels{reverts: map[string]revert{}}
+	l.Reset(global, components)
+	return l
+}
+
+// Reset makes global and components the configured levels and the ones
+// in effect, dropping every change made since and its timer.
+func (l *Levels) Reset(global slog.Level, components map[string]slog.Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, r := range l.reverts {
+		r.timer.Stop()
+		delete(l.reverts, key)
+	}
+	l.configured = maps.Clone(components)
+	if l.configured == nil {
+		l.configured = map[string]slog.Level{}
+	}
+	l.configured[""] = global
+	l.current = maps.Clone(l.configured)
+	l.updateMin()
+}
+
+// Set sets the level of component, or the global level if component is
+// empty. If revertAfter is positive the configured level comes back after
+// it; otherwise the level stays until it is changed again.
+func (l *Levels) Set(component string, level slog.Level, revertAfter time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopRevert(component)
+	l.current[component] = level
+	if revertAfter > 0 {
+		var r revert
+		r.at = time.Now().Add(revertAfter)
+		r.timer = time.AfterFunc(revertAfter, func() {
+			l.mu.Lock()
+			// A later Set or Reset replaced this timer.
+			if l.reverts[component].timer != r.timer {
+				l.mu.Unlock()
+				return
+			}
+			delete(l.reverts, component)
+			old := l.current[component]
+			l.restore(component)
+			now := l.level(component)
+			// Logging checks the levels, so the lock is released first.
+			l.mu.Unlock()
+			slog.Warn("log level reverted", ComponentKey, component, "from", old, "to", now)
+		})
+		l.reverts[component] = r
+	}
+	l.updateMin()
+}
+
+// Unset puts back the configured level of component, or the global level
+// if component is empty.
+func (l *Levels) Unset(component string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopRevert(component)
+	l.restore(component)
+}
+
+// UnsetAll puts back every configured level.
+func (l *Levels) UnsetAll() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key := range l.reverts {
+		l.stopRevert(key)
+	}
+	l.current = maps.Clone(l.configured)
+	l.updateMin()
+}
+
+// Snapshot returns the global level and the levels of the components that
+// have one of their own.
+func (l *Levels) Snapshot() (global LevelState, components map[string]LevelState) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	components = map[string]LevelState{}
+	for key, level := range l.current {
+		s := LevelState{Level: level, RevertAt: l.reverts[key].at}
+		if key == "" {
+			global = s
+		} else {
+			components[key] = s
+		}
+	}
+	return global, components
+}
+
+// Enabled reports whether records of component at level are logged.
+// Records of no component, or of one without a level of its own, follow
+// the global level.
+func (l *Levels) Enabled(component string, level slog.Level) bool {
+	if level < slog.Level(l.min.Load()) {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return level >= l.level(component)
+}
+
+// level is the level in effect for component. l.mu must be held.
+func (l *Levels) level(component string) slog.Level {
+	if level, ok := l.current[component]; ok {
+		return level
+	}
+	return l.current[""]
+}
+
+// restore puts back the configured level of key. l.mu must be held.
+func (l *Levels) restore(key string) {
+	if level, ok := l.configured[key]; ok {
+		l.current[key] = level
+	} else {
+		delete(l.current, key)
+	}
+	l.updateMin()
+}
+
+// stopRevert cancels the timer of key. l.mu must be held.
+func (l *Levels) stopRevert(key string) {
+	if r, ok := l.reverts[key]; ok {
+		r.timer.Stop()
+		delete(l.reverts, key)
+	}
+}
+
+// updateMin recomputes min. l.mu must be held.
+func (l *Levels) updateMin() {
+	low := l.current[""]
+	for _, level := range l.current {
+		low = min(low, level)
+	}
+	l.min.Store(int64(low))
+}
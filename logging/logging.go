@@ -0,0 +1,140 @@
+// Package logging sets up the structured logger of the gateway.
+//
+// Records are written as text or JSON by log/slog. Every record logged
+// with a request context carries the fields of that request, such as its
+// ID, route and user, and every attribute passes through Redact, so
+// tokens, passwords and email addresses never reach the logs, not even
+// inside a logged protobuf message.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Formats lists the output formats New accepts.
+var Formats = []string{"text", "json"}
+
+// New returns a logger writing records to w in format, text or json, if
+// levels lets them through.
+func New(w io.Writer, format string, levels *Levels) (*slog.Logger, error) {
+	// Levels are checked by contextHandler, so the handlers let all
+	// records through.
+	opts := &slog.HandlerOptions{Level: slog.Level(math.MinInt), ReplaceAttr: Redact}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("logging: unknown format %q, use %s", format, strings.Join(Formats, " or "))
+	}
+	return slog.New(contextHandler{Handler: h, levels: levels}), nil
+}
+
+type fieldsKey struct{}
+
+// fields are the attributes of one request, filled in as the request is
+// handled, e.g. with the user once it is authenticated.
+type fields struct {
+	mu    sync.Mutex
+	attrs []slog.Attr
+}
+
+// NewContext returns a context whose records carry attrs and whatever is
+// added with AddAttrs later. Handlers down the chain share the fields, so
+// a request log written at the end sees the attributes added on the way.
+func NewContext(ctx context.Context, attrs ...slog.Attr) context.Context {
+	return context.WithValue(ctx, fieldsKey{}, &fields{attrs: attrs})
+}
+
+// AddAttrs adds attrs to the fields of ctx, replacing attributes with the
+// same key. Contexts without fields are left alone.
+func AddAttrs(ctx context.Context, attrs ...slog.Attr) {
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+next:
+	for _, a := range attrs {
+		for i := range f.attrs {
+			if f.attrs[i].Key == a.Key {
+				f.attrs[i] = a
+				continue next
+			}
+		}
+		f.attrs = append(f.attrs, a)
+	}
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	f, ok := ctx.Value(fieldsKey{}).(*fields)
+	if !ok {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]slog.Attr(nil), f.attrs...)
+}
+
+// contextHandler adds the request fields of the context to each record
+// and drops records below the level of their component.
+type contextHandler struct {
+	slog.Handler
+	levels *Levels
+	// component is set by With(logging.Auth) and the like.
+	component string
+}
+
+// Enabled only knows the level of a record, not yet its component, so it
+// lets through records any component might log.
+func (h contextHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if h.component != "" {
+		return h.levels.Enabled(h.component, level)
+	}
+	return level >= slog.Level(h.levels.min.Load())
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	component := h.component
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == ComponentKey {
+			component = a.Value.String()
+			return false
+		}
+		return true
+	})
+	if !h.levels.Enabled(component, r.Level) {
+		return nil
+	}
+	if attrs := attrsFrom(ctx); len(attrs) > 0 {
+		r = r.Clone()
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	component := h.component
+	for _, a := range attrs {
+		if a.Key == ComponentKey {
+			component = a.Value.String()
+		}
+	}
+	return contextHandler{Handler: h.Handler.WithAttrs(attrs), levels: h.levels, component: component}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{Handler: h.Handler.WithGroup(name), levels: h.levels, component: h.component}
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/InstaUpload/gateway/requestid"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Middleware starts the request fields of every request, its ID, method,
+// path and route, and logs the request once it is handled with its
+// status, size and latency. It has to run after requestid.Middleware.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := NewContext(r.Context(),
+			slog.String("request_id", requestid.FromContext(r.Context())),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			// The pattern is only known once chi has routed the request.
+			slog.Any("route", routeValue{chi.RouteContext(r.Context())}),
+		)
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		slog.LogAttrs(ctx, level, "request", HTTP,
+			slog.Int("status", status),
+			slog.Int("bytes", ww.BytesWritten()),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_addr", r.RemoteAddr),
+		)
+	})
+}
+
+type routeValue struct {
+	rctx *chi.Context
+}
+
+func (v routeValue) LogValue() slog.Value {
+	if v.rctx == nil {
+		return slog.StringValue("")
+	}
+	return slog.StringValue(v.rctx.RoutePattern())
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Redacted replaces the values of secret attributes.
+const Redacted = "[REDACTED]"
+
+// secretKeys are the parts of attribute and protobuf field names whose
+// values are never logged. Names are compared in lower case without _ and
+// -, so refresh_token, refreshToken and X-Refresh-Token all match token.
+var secretKeys = []string{"password", "token", "secret", "authorization", "cookie", "apikey"}
+
+// Redact is a slog.HandlerOptions.ReplaceAttr function. It replaces the
+// values of attributes named like secrets, masks email addresses and logs
+// protobuf messages as groups of their set fields, redacted the same way.
+// Errors, which often echo the input that caused them, are logged as
+// their messages passed through Scrub.
+func Redact(_ []string, a slog.Attr) slog.Attr {
+	if err, ok := a.Value.Any().(error); ok && a.Value.Kind() == slog.KindAny {
+		a.Value = slog.StringValue(Scrub(err.Error()))
+		return a
+	}
+	if msg, ok := a.Value.Any().(proto.Message); ok && a.Value.Kind() == slog.KindAny {
+		if m := msg.ProtoReflect(); m.IsValid() {
+			a.Value = messageValue(m)
+		}
+		return a
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+	key := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(a.Key))
+	for _, s := range secretKeys {
+		if strings.Contains(key, s) {
+			if !a.Value.Equal(slog.StringValue("")) {
+				a.Value = slog.StringValue(Redacted)
+			}
+			return a
+		}
+	}
+	if strings.Contains(key, "email") && a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(MaskEmail(a.Value.String()))
+	}
+	if (key == "err" || key == "error") && a.Value.Kind() == slog.KindString {
+		a.Value = slog.StringValue(Scrub(a.Value.String()))
+	}
+	return a
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	// tokenPattern matches JWTs and gateway tokens.
+	tokenPattern  = regexp.MustCompile(`eyJ[A-Za-z0-9_-]*\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|\bgw[ar]_[A-Za-z0-9_-]+`)
+	bearerPattern = regexp.MustCompile(`(?i)(\bbearer\s+)\S+`)
+	// secretPairPattern matches key=value and key: value pairs whose key is
+	// named like a secret, keeping the key.
+	secretPairPattern = regexp.MustCompile(`(?i)(\b[\w-]*(?:password|token|secret|authorization|cookie|api[_-]?key)[\w-]*"?\s*[:=]\s*)("[^"]*"|[^\s,;]+)`)
+)
+
+// Scrub masks the email addresses in s and replaces tokens and the values
+// of secrets written as key=value or key: value, for free text such as
+// error messages whose parts are not attributes of their own.
+func Scrub(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Redacted)
+	s = tokenPattern.ReplaceAllString(s, Redacted)
+	s = secretPairPattern.ReplaceAllStringFunc(s, func(pair string) string {
+		m := secretPairPattern.FindStringSubmatch(pair)
+		if strings.HasPrefix(m[2], `"`) {
+			return m[1] + `"` + Redacted + `"`
+		}
+		return m[1] + Redacted
+	})
+	return emailPattern.ReplaceAllStringFunc(s, MaskEmail)
+}
+
+// MaskEmail keeps the first character and the domain of an address, e.g.
+// j***@example.com, enough to tell users apart when debugging.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || local == "" {
+		return "***"
+	}
+	first := []rune(local)[0]
+	return string(first) + "***@" + domain
+}
+
+// messageValue returns the set fields of m as a group, with each field
+// passed through Redact.
+func messageValue(m protoreflect.Message) slog.Value {
+	var attrs []slog.Attr
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		attrs = append(attrs, Redact(nil, slog.Attr{Key: string(fd.Name()), Value: fieldValue(fd, v)}))
+		return true
+	})
+	return slog.GroupValue(attrs...)
+}
+
+func fieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) slog.Value {
+	switch {
+	case fd.IsList():
+		list := v.List()
+		items := make([]any, list.Len())
+		for i := range items {
+			items[i] = elementValue(fd, list.Get(i))
+		}
+		return slog.AnyValue(items)
+	case fd.IsMap():
+		entries := map[string]any{}
+		v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+			entries[k.String()] = elementValue(fd.MapValue(), v)
+			return true
+		})
+		return slog.AnyValue(entries)
+	default:
+		return singularValue(fd, v)
+	}
+}
+
+// singularValue converts one value of fd, ignoring whether fd is a list.
+func singularValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) slog.Value {
+	switch {
+	case fd.Message() != nil:
+		return messageValue(v.Message())
+	case fd.Kind() == protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return slog.StringValue(string(ev.Name()))
+		}
+		return slog.Int64Value(int64(v.Enum()))
+	case fd.Kind() == protoreflect.BytesKind:
+		return slog.StringValue(fmt.Sprintf("[%d bytes]", len(v.Bytes())))
+	default:
+		return slog.AnyValue(v.Interface())
+	}
+}
+
+// elementValue converts a list or map element. Messages become maps, as
+// handlers do not look into the values of lists.
+func elementValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	if fd.Message() == nil {
+		return singularValue(fd, v).Any()
+	}
+	return groupMap(messageValue(v.Message()))
+}
+
+func groupMap(v slog.Value) map[string]any {
+	out := map[string]any{}
+	for _, a := range v.Group() {
+		if a.Value.Kind() == slog.KindGroup {
+			out[a.Key] = groupMap(a.Value)
+		} else {
+			out[a.Key] = a.Value.Any()
+		}
+	}
+	return out
+}
@@ -2,19 +2,52 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"expvar"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
 	pb "github.com/InstaUpload/common/api"
-	_ "github.com/InstaUpload/gateway/docs"
-	"github.com/InstaUpload/gateway/utils"
+	"github.com/InstaUpload/gateway/authcache"
+	"github.com/InstaUpload/gateway/authz"
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/docs"
+	"github.com/InstaUpload/gateway/health"
+	"github.com/InstaUpload/gateway/jwtauth"
+	"github.com/InstaUpload/gateway/lockout"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/metrics"
+	"github.com/InstaUpload/gateway/ratelimit"
+	"github.com/InstaUpload/gateway/requestid"
+	"github.com/InstaUpload/gateway/retry"
+	"github.com/InstaUpload/gateway/session"
+	"github.com/InstaUpload/gateway/tracing"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
 )
 
-func getUserService(ctx context.Context, addr string) (pb.UserServiceClient, *grpc.ClientConn, error) {
-	conn, err := grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+func getUserService(ctx context.Context, addr string, ka config.KeepaliveConfig, interceptors ...grpc.UnaryClientInterceptor) (pb.UserServiceClient, *grpc.ClientConn, error) {
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithChainUnaryInterceptor(interceptors...),
+	}
+	if ka.Time > 0 {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                ka.Time,
+			Timeout:             ka.Timeout,
+			PermitWithoutStream: ka.PermitWithoutStream,
+		}))
+	}
+	conn, err := grpc.DialContext(ctx, addr, opts...)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -23,40 +56,246 @@ func getUserService(ctx context.Context, addr string) (pb.UserServiceClient, *gr
 	return userService, conn, nil
 }
 
-func run(mux http.Handler) error {
-	srv := &http.Server{
-		Addr:         utils.GetEnvString("HTTP_SERVER_PORT", ":5000"),
+// newRetrier returns a retrier for the user service methods in cfg.
+func newRetrier(cfg config.RetryConfig) *retry.Retrier {
+	policies := make(map[string]retry.Policy, len(cfg.Methods))
+	for method, p := range cfg.Methods {
+		policy := retry.Policy{MaxAttempts: p.MaxAttempts, RequireIdempotencyKey: p.RequireIdempotencyKey}
+		for _, name := range p.RetryCodes() {
+			// Validate only lets through names gRPC knows, so one that does
+			// not parse is a programming error.
+			var code codes.Code
+			if err := code.UnmarshalJSON([]byte(`"` + name + `"`)); err != nil {
+				panic(fmt.Sprintf("retry code %q of %s passed validation: %v", name, method, err))
+			}
+			policy.Codes = append(policy.Codes, code)
+		}
+		policies["/"+pb.UserService_ServiceDesc.ServiceName+"/"+method] = policy
+	}
+	return retry.New(policies,
+		retry.Backoff{Initial: cfg.Backoff.Initial, Max: cfg.Backoff.Max, Multiplier: cfg.Backoff.Multiplier},
+		retry.Budget{MaxTokens: cfg.Budget.MaxTokens, TokenRatio: cfg.Budget.TokenRatio})
+}
+
+// reloadLogLevels loads the configuration again on every SIGHUP and puts
+// its log levels in effect, undoing changes made on the admin listener.
+// Other settings only change on restart.
+func reloadLogLevels(ctx context.Context, levels *logging.Levels) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		cfg, err := config.Load(os.Args[1:])
+		if err != nil {
+			slog.Error("error reloading config, keeping log levels", "err", err)
+			continue
+		}
+		levels.Reset(cfg.Log.SlogLevel(), cfg.Log.ComponentLevels())
+		slog.Warn("reloaded log levels", "level", cfg.Log.Level, "components", cfg.Log.Components)
+	}
+}
+
+// fatal logs msg with args as an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newServer(cfg config.HTTPConfig, mux http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         cfg.Addr,
 		Handler:      mux,
-		WriteTimeout: time.Second * 30,
-		ReadTimeout:  time.Second * 40,
-		IdleTimeout:  time.Minute,
+		WriteTimeout: cfg.WriteTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
 	}
-	log.Println("Http server running in port :5000")
+}
 
-	return srv.ListenAndServe()
+// serve runs srv on ln until it is shut down and sends any other error
+// to errc.
+func serve(name string, srv *http.Server, ln net.Listener, errc chan<- error) {
+	slog.Info(name+" server running", "addr", ln.Addr().String())
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		errc <- fmt.Errorf("%s server: %w", name, err)
+	}
 }
 
-//	@title						InstaUpload
-//	@version					0.1
-//	@description				This is swagger api page for InstaUpload gateway service.
-//	@contact.name				Sahaj
-//	@contact.email				gpt.sahaj28@gmail.com
-//	@host						localhost:5000
-//	@BasePath					/v1
-//	@securityDefinitions.apikey	ApiKeyAuth
-//	@in							header
-//	@name						Authorization
+// @title						InstaUpload
+// @version					0.1
+// @description				This is swagger api page for InstaUpload gateway service.
+// @description				Errors are returned as RFC 7807 application/problem+json bodies (main.ProblemDetails); clients should branch on the code field, the catalog of codes is listed in main.ErrorCode.
+// @contact.name				Sahaj
+// @contact.email				gpt.sahaj28@gmail.com
+// @host						localhost:5000
+// @BasePath					/v1
+// @securityDefinitions.apikey	ApiKeyAuth
+// @in							header
+// @name						Authorization
 func main() {
-	ctx := context.Background()
-	// TODO: Get user service address from env
-	userService, conn, err := getUserService(ctx, "localhost:5003")
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("failed to load config", "err", err)
+	}
+	logLevels := logging.NewLevels(cfg.Log.SlogLevel(), cfg.Log.ComponentLevels())
+	logger, err := logging.New(os.Stderr, cfg.Log.Format, logLevels)
+	if err != nil {
+		fatal("failed to set up logging", "err", err)
+	}
+	slog.SetDefault(logger)
+	docs.SwaggerInfo.Host = cfg.Swagger.Host
+	docs.SwaggerInfo.Schemes = []string{cfg.Swagger.Scheme}
+
+	// The context ends with the first SIGINT or SIGTERM, which starts the
+	// shutdown; background work started with it stops then.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	collectors := metrics.New()
+	retrier := newRetrier(cfg.GRPC.Retry)
+	collectors.RegisterRetrier(retrier)
+	// Retries run outside of metrics, so every attempt is timed.
+	interceptors := []grpc.UnaryClientInterceptor{retrier.UnaryClientInterceptor(), collectors.UnaryClientInterceptor(), requestid.UnaryClientInterceptor()}
+	var tracer *tracing.Tracer
+	if cfg.Tracing.Enabled() {
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			slog.Error("error exporting spans", "err", err)
+		}))
+		exporter, err := tracing.NewExporter(ctx, cfg.Tracing, os.Stdout)
+		if err != nil {
+			fatal("failed to set up tracing", "exporter", cfg.Tracing.Exporter, "err", err)
+		}
+		provider := tracing.NewProvider(cfg.Tracing, exporter)
+		defer func() {
+			if err := provider.Shutdown(context.Background()); err != nil {
+				slog.Error("error flushing spans", "err", err)
+			}
+		}()
+		tracer = tracing.New(provider)
+		// The tracing interceptor runs first, so its span covers the whole
+		// call, retries included.
+		interceptors = append([]grpc.UnaryClientInterceptor{tracer.UnaryClientInterceptor()}, interceptors...)
+	}
+	userService, conn, err := getUserService(ctx, cfg.Services.User.Addr, cfg.GRPC.Keepalive, interceptors...)
+	if err != nil {
+		fatal("can not get user service", "addr", cfg.Services.User.Addr, "err", err)
+	}
+	handler := Handler{
+		userClient: userService,
+		cfg:        cfg,
+		revoked:    session.NewRevocationList(cfg.Auth.RevocationMaxEntries),
+		policy:     authz.NewEngine(authz.DefaultPolicy()),
+		tracer:     tracer,
+		metrics:    collectors,
+		logLevels:  logLevels,
+		health: health.NewChecker([]health.Dependency{
+			{Name: "user", Conn: conn, Service: cfg.Services.User.HealthService},
+		}, cfg.Health.StartupGrace, cfg.Health.Timeout, cfg.Health.MaxAge),
+	}
+	if cfg.Auth.Policy.File != "" {
+		policy, err := authz.LoadFile(cfg.Auth.Policy.File)
+		if err != nil {
+			fatal("failed to load policy", "file", cfg.Auth.Policy.File, "err", err)
+		}
+		handler.policy = authz.NewEngine(policy)
+		go handler.policy.Watch(ctx, cfg.Auth.Policy.File, cfg.Auth.Policy.ReloadInterval)
+	}
+	if cfg.Auth.Cache.Enabled {
+		handler.authCache = authcache.New(cfg.Auth.Cache.MaxEntries, cfg.Auth.Cache.TTL)
+		expvar.Publish("auth_cache", expvar.Func(func() any {
+			stats := handler.authCache.Stats()
+			return map[string]any{"stats": stats, "hit_rate": stats.HitRate()}
+		}))
+		collectors.RegisterAuthCache(handler.authCache)
+	}
+	if cfg.Auth.JWT.Enabled {
+		source := jwtauth.FileSource(cfg.Auth.JWT.JWKSFile)
+		if cfg.Auth.JWT.JWKSURL != "" {
+			source = jwtauth.URLSource(http.DefaultClient, cfg.Auth.JWT.JWKSURL)
+		}
+		keys := jwtauth.NewKeySet(source, cfg.GRPC.DefaultDeadline)
+		// Until a key set is loaded every token is checked by the user
+		// service, so a JWKS outage at startup is not fatal.
+		if err := keys.Refresh(ctx); err != nil {
+			slog.Warn("can not load jwks, falling back to AuthUser", "err", err)
+		}
+		go keys.Run(ctx, cfg.Auth.JWT.RefreshInterval)
+		handler.jwt = jwtauth.NewVerifier(keys, cfg.Auth.JWT)
+		expvar.Publish("jwks", expvar.Func(func() any {
+			return map[string]any{"keys": keys.Len(), "loaded_at": keys.LoadedAt()}
+		}))
+	}
+	// Login lockouts tell clients apart by address as well, so the trusted
+	// proxies apply even when rate limiting is off.
+	handler.trustedProxies = cfg.RateLimit.TrustedPrefixes()
+	if cfg.RateLimit.Enabled {
+		limiter := ratelimit.NewMemory(cfg.RateLimit.MaxKeys)
+		handler.limiter = limiter
+		expvar.Publish("rate_limit", expvar.Func(func() any {
+			return map[string]any{"keys": limiter.Len()}
+		}))
+		collectors.RegisterRateLimiter(limiter.Len)
+	}
+	if cfg.Auth.Lockout.Enabled {
+		tracker := lockout.New(map[lockout.Kind]lockout.Policy{
+			lockout.KindEmail: lockout.Policy(cfg.Auth.Lockout.Email),
+			lockout.KindIP:    lockout.Policy(cfg.Auth.Lockout.IP),
+		}, cfg.Auth.Lockout.MaxEntries)
+		handler.lockout = tracker
+		expvar.Publish("login_lockout", expvar.Func(func() any {
+			return map[string]any{"keys": tracker.Len(), "blocked": len(tracker.Blocked(""))}
+		}))
+	}
+	if cfg.Auth.Enumeration.Enabled {
+		handler.outcomes = expvar.NewMap("enumeration")
+	}
+	if cfg.Auth.Password.Enabled {
+		if dir := cfg.Auth.Password.BreachedDir; dir != "" {
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				fatal("breached password directory is not a readable directory", "dir", dir, "err", err)
+			}
+		}
+		handler.passwords = newPasswordPolicy(cfg.Auth.Password)
+		handler.passwordRejects = expvar.NewMap("password_policy")
+	}
+	if cfg.Auth.Refresh.Enabled {
+		handler.sessions = session.NewManager(session.NewMemoryStore(), cfg.Auth.AccessTokenTTL, cfg.Auth.Refresh.TokenTTL)
+	}
+	ln, err := net.Listen("tcp", cfg.HTTP.Addr)
 	if err != nil {
-		log.Fatal("can not get user service")
+		fatal("failed to listen", "addr", cfg.HTTP.Addr, "err", err)
 	}
-	defer conn.Close()
-	handler := Handler{userClient: userService}
+	go reloadLogLevels(ctx, logLevels)
+	errc := make(chan error, 2)
 	mux := handler.mount()
-	if err := run(mux); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	servers := []*http.Server{newServer(cfg.HTTP, mux)}
+	go serve("http", servers[0], ln, errc)
+	if cfg.Admin.Addr != "" {
+		adminLn, err := listenAdmin(cfg.Admin)
+		if err != nil {
+			fatal("failed to listen", "addr", cfg.Admin.Addr, "err", err)
+		}
+		admin := newAdminServer(handler.mountAdmin(mux))
+		servers = append(servers, admin)
+		go serve("admin", admin, adminLn, errc)
+	}
+	select {
+	case err := <-errc:
+		fatal("failed to start server", "err", err)
+	case <-ctx.Done():
+	}
+	// A second signal stops the gateway at once.
+	stop()
+	handler.shutdown(cfg.HTTP, servers...)
+	if err := conn.Close(); err != nil {
+		slog.Error("error closing user service connection", "err", err)
+	} else {
+		slog.Info("closed user service connection")
 	}
 }
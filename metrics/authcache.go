@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"github.com/InstaUpload/gateway/authcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterAuthCache reports the counters of c.
+func (m *Metrics) RegisterAuthCache(c *authcache.Cache) {
+	m.registry.MustRegister(authCacheCollector{c})
+}
+
+var (
+	authCacheLookups = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "auth_cache", "lookups_total"),
+		"Tokens looked up in the auth cache, by result: hit or miss.",
+		[]string{"result"}, nil)
+	authCacheCoalesced = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "auth_cache", "coalesced_total"),
+		"Misses that shared their AuthUser call with another request.",
+		nil, nil)
+	authCacheEvictions = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "auth_cache", "evictions_total"),
+		"Entries dropped to make room for new ones.",
+		nil, nil)
+	authCacheInvalidations = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "auth_cache", "invalidations_total"),
+		"Entries removed on logout, revocation or changes to the user.",
+		nil, nil)
+	authCacheEntries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "auth_cache", "entries"),
+		"Entries in the auth cache.",
+		nil, nil)
+)
+
+// authCacheCollector reads the statistics of the cache once per scrape.
+type authCacheCollector struct {
+	cache *authcache.Cache
+}
+
+func (c authCacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- authCacheLookups
+	ch <- authCacheCoalesced
+	ch <- authCacheEvictions
+	ch <- authCacheInvalidations
+	ch <- authCacheEntries
+}
+
+func (c authCacheCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.cache.Stats()
+	ch <- prometheus.MustNewConstMetric(authCacheLookups, prometheus.CounterValue, float64(s.Hits), "hit")
+	ch <- prometheus.MustNewConstMetric(authCacheLookups, prometheus.CounterValue, float64(s.Misses), "miss")
+	ch <- prometheus.MustNewConstMetric(authCacheCoalesced, prometheus.CounterValue, float64(s.Coalesced))
+	ch <- prometheus.MustNewConstMetric(authCacheEvictions, prometheus.CounterValue, float64(s.Evictions))
+	ch <- prometheus.MustNewConstMetric(authCacheInvalidations, prometheus.CounterValue, float64(s.Invalidations))
+	ch <- prometheus.MustNewConstMetric(authCacheEntries, prometheus.GaugeValue, float64(s.Size))
+}
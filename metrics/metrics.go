@@ -0,0 +1,95 @@
+// Package metrics collects Prometheus metrics of the gateway: latencies of
+// HTTP requests by route and of gRPC calls by method, requests in flight,
+// and the counters of the auth cache and the rate limiter.
+//
+// Metrics are kept in a registry of their own and served by Handler, which
+// belongs on the admin listener rather than the public one.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+// Metrics holds the collectors of the gateway.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpDuration *prometheus.HistogramVec
+	httpInFlight prometheus.Gauge
+	grpcDuration *prometheus.HistogramVec
+	grpcInFlight *prometheus.GaugeVec
+	rateLimit    *prometheus.CounterVec
+}
+
+// New returns metrics registered with a new registry, together with the
+// Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Time taken to answer HTTP requests, by chi route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"route", "method", "status"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "http",
+			Name:      "requests_in_flight",
+			Help:      "HTTP requests being handled.",
+		}),
+		grpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "call_duration_seconds",
+			Help:      "Time taken by gRPC calls to backend services, by method and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+		grpcInFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "grpc_client",
+			Name:      "calls_in_flight",
+			Help:      "gRPC calls to backend services waiting for their response, by method.",
+		}, []string{"method"}),
+		rateLimit: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "rate_limit",
+			Name:      "requests_total",
+			Help:      "Requests checked against a rate limit, by limit and result: allowed, rejected or error.",
+		}, []string{"limit", "result"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpDuration, m.httpInFlight, m.grpcDuration, m.grpcInFlight, m.rateLimit,
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// RateLimited counts a request checked against limit. result is allowed,
+// rejected or error.
+func (m *Metrics) RateLimited(limit, result string) {
+	m.rateLimit.WithLabelValues(limit, result).Inc()
+}
+
+// RegisterRateLimiter reports the number of keys the limiter tracks.
+func (m *Metrics) RegisterRateLimiter(keys func() int) {
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "rate_limit",
+		Name:      "keys",
+		Help:      "Clients the rate limiter keeps state for.",
+	}, func() float64 { return float64(keys()) }))
+}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"github.com/InstaUpload/gateway/retry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterRetrier reports the counters of r.
+func (m *Metrics) RegisterRetrier(r *retry.Retrier) {
+	m.registry.MustRegister(retryCollector{r})
+}
+
+var (
+	grpcRetries = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "grpc_client", "retries_total"),
+		"gRPC calls made again after a failed attempt, by full method name.",
+		[]string{"method"}, nil)
+	grpcRetriesThrottled = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "grpc_client", "retries_throttled_total"),
+		"Failed gRPC calls not retried because the retry budget was spent, by full method name.",
+		[]string{"method"}, nil)
+	grpcRetryBudget = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "grpc_client", "retry_budget_tokens"),
+		"Tokens left in the retry budget; retries stop at half its size.",
+		nil, nil)
+)
+
+// retryCollector reads the counters of the retrier once per scrape.
+type retryCollector struct {
+	retrier *retry.Retrier
+}
+
+func (c retryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- grpcRetries
+	ch <- grpcRetriesThrottled
+	ch <- grpcRetryBudget
+}
+
+func (c retryCollector) Collect(ch chan<- prometheus.Metric) {
+	s := c.retrier.Stats()
+	for method, n := range s.Retries {
+		ch <- prometheus.MustNewConstMetric(grpcRetries, prometheus.CounterValue, float64(n), method)
+	}
+	for method, n := range s.Throttled {
+		ch <- prometheus.MustNewConstMetric(grpcRetriesThrottled, prometheus.CounterValue, float64(n), method)
+	}
+	ch <- prometheus.MustNewConstMetric(grpcRetryBudget, prometheus.GaugeValue, s.Tokens)
+}
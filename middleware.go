@@ -1,49 +1,265 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
-	"strings"
+	"time"
 
 	pb "github.com/InstaUpload/common/api"
-	common "github.com/InstaUpload/common/types"
+	"github.com/InstaUpload/gateway/authctx"
+	"github.com/InstaUpload/gateway/authz"
+	"github.com/InstaUpload/gateway/jwtauth"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/session"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
 )
 
 func (h *Handler) GetCurrentUser(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract the token from the request header
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		// Extract the token from the request header or cookie
+		token, ok := h.bearerToken(r)
+		if !ok {
+			SendProblemResponse(w, r, ErrCodeUnauthorized, "")
 			return
 		}
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		method := authctx.MethodUserService
+		info := authctx.Token{
+			Hash:      session.Hash(token),
+			ExpiresAt: tokenExpiry(token, time.Now(), h.cfg.Auth.UpstreamTokenTTL),
+		}
+		// Logged out tokens are rejected without asking the user service.
+		if h.revoked.IsTokenRevoked(info.Hash) {
+			SendProblemResponse(w, r, ErrCodeTokenRevoked, "")
 			return
 		}
-		token := parts[1]
-		var req = pb.AuthUserRequest{}
-		req.Token = token
-		resp, err := h.userClient.AuthUser(r.Context(), &req)
-		if err != nil {
-			if errors.Is(err, common.ErrIncorrectDataReceived) {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		ctx := r.Context()
+		if h.sessions != nil {
+			at, family, err := h.sessions.Resolve(ctx, token)
+			switch {
+			case err == nil:
+				if h.revoked.IsUserTokenRevoked(family.UserID, at.IssuedAt) {
+					SendProblemResponse(w, r, ErrCodeTokenRevoked, "")
+					return
+				}
+				// Authenticate with the user service token behind the session.
+				token = family.UpstreamToken
+				method = authctx.MethodSession
+				info.FamilyID = family.ID
+				info.IssuedAt = at.IssuedAt
+				info.ExpiresAt = at.ExpiresAt
+			case errors.Is(err, session.ErrNotGatewayToken):
+				// A user service token, pass it through unchanged.
+			case errors.Is(err, session.ErrExpired):
+				SendProblemResponse(w, r, ErrCodeTokenExpired, "")
+				return
+			case errors.Is(err, session.ErrNotFound), errors.Is(err, session.ErrRevoked):
+				SendProblemResponse(w, r, ErrCodeTokenInvalid, "")
+				return
+			default:
+				slog.ErrorContext(ctx, "error resolving session", logging.Auth, "err", err)
+				SendProblemResponse(w, r, ErrCodeInternal, "")
 				return
 			}
-			if errors.Is(err, common.ErrDataNotFound) {
-				http.Error(w, "User not found", http.StatusNotFound)
+		}
+		// JWTs are verified locally; opaque tokens, and JWTs while no key
+		// set is loaded, are left to the user service.
+		var resp *pb.AuthUserResponse
+		var jwtClaims map[string][]string
+		if h.jwt != nil {
+			claims, err := h.jwt.Verify(ctx, token)
+			switch {
+			case err == nil:
+				if info.FamilyID == "" {
+					if h.revoked.IsUserTokenRevoked(claims.User.Id, claims.IssuedAt) {
+						SendProblemResponse(w, r, ErrCodeTokenRevoked, "")
+						return
+					}
+					method = authctx.MethodJWT
+					info.IssuedAt = claims.IssuedAt
+					info.ExpiresAt = claims.ExpiresAt
+				}
+				resp = claims.User
+				jwtClaims = claims.Values
+			case errors.Is(err, jwtauth.ErrNotJWT), errors.Is(err, jwtauth.ErrNoKeys):
+			case errors.Is(err, jwtauth.ErrExpired):
+				SendProblemResponse(w, r, ErrCodeTokenExpired, "")
+				return
+			default:
+				slog.InfoContext(ctx, "rejecting jwt", logging.Auth, "err", err)
+				SendProblemResponse(w, r, ErrCodeTokenInvalid, "")
 				return
 			}
-			log.Println("error authenticating user: ", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		fetch := func(ctx context.Context) (*pb.AuthUserResponse, error) {
+			var req = pb.AuthUserRequest{}
+			req.Token = token
+			authCtx, cancel := context.WithTimeout(ctx, h.cfg.GRPC.DefaultDeadline)
+			defer cancel()
+			return h.userClient.AuthUser(authCtx, &req)
+		}
+		var err error
+		switch {
+		case resp != nil:
+		case h.authCache != nil:
+			resp, err = h.authCache.Get(ctx, info.Hash, info.ExpiresAt, fetch)
+		default:
+			resp, err = fetch(ctx)
+		}
+		if err != nil {
+			// A token that is malformed, expired or belongs to a deleted
+			// user is an authentication failure, not a missing resource.
+			sendGRPCError(w, r, err, "authenticating user", map[codes.Code]httpError{
+				codes.InvalidArgument: {ErrCodeTokenInvalid, ""},
+				codes.NotFound:        {ErrCodeTokenInvalid, ""},
+			})
 			return
 		}
-		// Set resp(User) in the request context
-		ctx := context.WithValue(r.Context(), common.CurrentUserKey, resp)
+		principal := authctx.New(resp, info, method)
+		principal.Claims = jwtClaims
+		ctx = authctx.NewContext(ctx, principal)
+		logging.AddAttrs(ctx, slog.Int64("user_id", resp.Id), slog.String("auth_method", string(method)))
 		// Call the next handler
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Authorize evaluates the authorization policy against the request and
+// the current user; in audit mode denials are only logged. It must run
+// after GetCurrentUser.
+func (h *Handler) Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := currentPrincipal(w, r)
+		if !ok {
+			return
+		}
+		policy := h.policy.Policy()
+		in := authz.Input{
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Params:    map[string]string{},
+			Query:     r.URL.Query(),
+			Header:    r.Header,
+			Principal: user,
+		}
+		if rctx := chi.RouteContext(r.Context()); rctx != nil {
+			in.Route = rctx.RoutePattern()
+			for i, key := range rctx.URLParams.Keys {
+				in.Params[key] = rctx.URLParams.Values[i]
+			}
+		}
+		if policy.UsesBody() {
+			body, err := readPolicyBody(r)
+			if err != nil {
+				SendProblemResponse(w, r, ErrCodeInvalidPayload, "")
+				slog.InfoContext(r.Context(), "error reading request body", logging.HTTP, "err", err)
+				return
+			}
+			in.Body = body
+		}
+		d := policy.Evaluate(in)
+		if policy.Mode == authz.ModeAudit && d.Rule != "" && d.Allowed() {
+			slog.InfoContext(r.Context(), "policy audit: rule matched", logging.Auth, "rule", d.Rule, "effect", d.Effect, "role", user.Role())
+		}
+		if !d.Allowed() {
+			reason := "default policy"
+			if d.Rule != "" {
+				reason = fmt.Sprintf("rule %q", d.Rule)
+			}
+			if policy.Mode != authz.ModeAudit {
+				h.forbid(w, r, user, reason, "")
+				return
+			}
+			slog.WarnContext(r.Context(), "policy audit: would deny", logging.Auth, "role", user.Role(), "reason", reason)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission lets the request through only if the role of the
+// current user is granted p by the policy, whatever the policy's mode. It
+// must run after GetCurrentUser.
+func (h *Handler) RequirePermission(p authz.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := currentPrincipal(w, r)
+			if !ok {
+				return
+			}
+			if !h.policy.Policy().Roles.Allows(user.Roles, p) {
+				h.forbid(w, r, user, "missing permission "+string(p), fmt.Sprintf("Missing permission %s", p))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forbid answers 403 for a denied request.
+func (h *Handler) forbid(w http.ResponseWriter, r *http.Request, user *authctx.Principal, reason, detail string) {
+	slog.InfoContext(r.Context(), "denied", logging.Auth, "role", user.Role(), "reason", reason)
+	SendProblemResponse(w, r, ErrCodeForbidden, detail)
+}
+
+// currentPrincipal returns the caller of r. Routes not behind
+// GetCurrentUser have none and are answered with 401.
+func currentPrincipal(w http.ResponseWriter, r *http.Request) (*authctx.Principal, bool) {
+	p, err := authctx.FromContext(r.Context())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "request has no principal, is the route behind GetCurrentUser?", logging.Auth)
+		SendProblemResponse(w, r, ErrCodeUnauthorized, "")
+		return nil, false
+	}
+	return p, true
+}
+
+// maxPolicyBody bounds the request body read for policy evaluation.
+const maxPolicyBody = 1 << 20
+
+// readPolicyBody returns the top level fields of a JSON object body as
+// strings and puts the body back for the handler. Other bodies yield no
+// fields; rejecting them is up to the handler.
+func readPolicyBody(r *http.Request) (map[string]string, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxPolicyBody))
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(data), r.Body))
+	var raw map[string]json.RawMessage
+	if json.Unmarshal(data, &raw) != nil {
+		return nil, nil
+	}
+	fields := make(map[string]string, len(raw))
+	for k, v := range raw {
+		var s string
+		if json.Unmarshal(v, &s) == nil {
+			fields[k] = s
+			continue
+		}
+		fields[k] = string(v)
+	}
+	return fields, nil
+}
+
+// invalidateAuthToken drops the cached AuthUser response of a token.
+func (h *Handler) invalidateAuthToken(hash string) {
+	if h.authCache != nil {
+		h.authCache.Invalidate(hash)
+	}
+}
+
+// invalidateAuthUser drops every cached AuthUser response of userID.
+func (h *Handler) invalidateAuthUser(userID int64) {
+	if h.authCache != nil {
+		h.authCache.InvalidateUser(userID)
+	}
+}
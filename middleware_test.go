@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/InstaUpload/gateway/authz"
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/jwtauth"
+	"github.com/InstaUpload/gateway/session"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtIssuer signs tokens as the user service would.
+type jwtIssuer struct {
+	key  ed25519.PrivateKey
+	jwks []byte
+}
+
+func newJWTIssuer(t *testing.T) *jwtIssuer {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "OKP", "crv": "Ed25519", "kid": "k1", "x": base64.RawURLEncoding.EncodeToString(pub),
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jwtIssuer{key: priv, jwks: jwks}
+}
+
+// sign returns a token of user 42 issued at iat, or without iat when it
+// is zero.
+func (i *jwtIssuer) sign(t *testing.T, iat time.Time) string {
+	t.Helper()
+	claims := jwt.MapClaims{"sub": "42", "role": "creator"}
+	if !iat.IsZero() {
+		claims["iat"] = iat.Unix()
+	}
+	return i.signClaims(t, claims)
+}
+
+// signClaims returns a token of claims, which expires in an hour.
+func (i *jwtIssuer) signClaims(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	tok := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	tok.Header["kid"] = "k1"
+	s, err := tok.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newJWTHandler returns a handler verifying the tokens of issuer locally.
+func newJWTHandler(t *testing.T, issuer *jwtIssuer) *Handler {
+	t.Helper()
+	cfg := config.Default()
+	keys := jwtauth.NewKeySet(func(context.Context) ([]byte, error) { return issuer.jwks, nil }, time.Second)
+	if err := keys.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &Handler{
+		cfg:     cfg,
+		revoked: session.NewRevocationList(cfg.Auth.RevocationMaxEntries),
+		jwt:     jwtauth.NewVerifier(keys, cfg.Auth.JWT),
+		policy:  authz.NewEngine(authz.DefaultPolicy()),
+	}
+}
+
+// mountUserRoutes returns the routes of h that only need GetCurrentUser.
+func mountUserRoutes(h *Handler) http.Handler {
+	r := chi.NewRouter()
+	r.Use(h.GetCurrentUser)
+	r.Post("/v1/users/logout-all", h.LogoutAllUser)
+	r.Get("/v1/users/me", func(w http.ResponseWriter, r *http.Request) {})
+	return r
+}
+
+func send(h http.Handler, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestLogoutAllThenFreshLogin(t *testing.T) {
+	issuer := newJWTIssuer(t)
+	h := mountUserRoutes(newJWTHandler(t, issuer))
+	old := issuer.sign(t, time.Now().Add(-10*time.Second))
+	other := issuer.sign(t, time.Now().Add(-5*time.Second))
+
+	if code := send(h, http.MethodGet, "/v1/users/me", old); code != http.StatusOK {
+		t.Fatalf("before logout: status %d, want 200", code)
+	}
+	if code := send(h, http.MethodPost, "/v1/users/logout-all", old); code != http.StatusOK {
+		t.Fatalf("logout-all: status %d, want 200", code)
+	}
+	for name, token := range map[string]string{"logged out token": old, "other session": other} {
+		if code := send(h, http.MethodGet, "/v1/users/me", token); code != http.StatusUnauthorized {
+			t.Errorf("%s after logout-all: status %d, want 401", name, code)
+		}
+	}
+
+	// A login after the logout gets a token issued in a later second; the
+	// leeway lets it be a second ahead of the gateway's clock.
+	fresh := issuer.sign(t, time.Now().Add(time.Second))
+	if code := send(h, http.MethodGet, "/v1/users/me", fresh); code != http.StatusOK {
+		t.Errorf("fresh login after logout-all: status %d, want 200", code)
+	}
+}
+
+func TestJWTWithoutIssuedAtRejected(t *testing.T) {
+	issuer := newJWTIssuer(t)
+	h := mountUserRoutes(newJWTHandler(t, issuer))
+	if code := send(h, http.MethodGet, "/v1/users/me", issuer.sign(t, time.Time{})); code != http.StatusUnauthorized {
+		t.Errorf("token without iat: status %d, want 401", code)
+	}
+}
+
+func TestAuditModeEnforcesPermissions(t *testing.T) {
+	issuer := newJWTIssuer(t)
+	h := newJWTHandler(t, issuer)
+	policy := authz.DefaultPolicy()
+	policy.Mode = authz.ModeAudit
+	policy.Default = authz.Deny
+	h.policy = authz.NewEngine(policy)
+
+	r := chi.NewRouter()
+	r.Use(h.GetCurrentUser, h.Authorize)
+	r.With(h.RequirePermission(authz.PermUpdateRole)).Put("/v1/users/update-role", func(w http.ResponseWriter, r *http.Request) {})
+	r.Get("/v1/users/me", func(w http.ResponseWriter, r *http.Request) {})
+
+	token := issuer.sign(t, time.Now())
+	if code := send(r, http.MethodGet, "/v1/users/me", token); code != http.StatusOK {
+		t.Errorf("request the rules deny in audit mode: status %d, want 200", code)
+	}
+	if code := send(r, http.MethodPut, "/v1/users/update-role", token); code != http.StatusForbidden {
+		t.Errorf("request without the permission in audit mode: status %d, want 403", code)
+	}
+}
+
+func TestExamplePolicyLimitsEditorsToInvitingOwners(t *testing.T) {
+	issuer := newJWTIssuer(t)
+	h := newJWTHandler(t, issuer)
+	policy, err := authz.LoadFile("policy.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.policy = authz.NewEngine(policy)
+
+	r := chi.NewRouter()
+	r.Use(h.GetCurrentUser)
+	r.With(h.Authorize).Get("/v1/owners/{owner}/uploads", func(w http.ResponseWriter, r *http.Request) {})
+
+	editor := issuer.signClaims(t, jwt.MapClaims{"sub": "42", "role": "editor", "iat": time.Now().Unix(), "owners": []int{7, 9}})
+	unlisted := issuer.signClaims(t, jwt.MapClaims{"sub": "42", "role": "editor", "iat": time.Now().Unix()})
+	owner := issuer.signClaims(t, jwt.MapClaims{"sub": "8", "role": "creator", "iat": time.Now().Unix()})
+	for _, tc := range []struct {
+		name, token, owner string
+		want               int
+	}{
+		{"editor for an inviting owner", editor, "9", http.StatusOK},
+		{"editor for another owner", editor, "8", http.StatusForbidden},
+		{"editor without an owners claim", unlisted, "9", http.StatusForbidden},
+		{"owner", owner, "8", http.StatusOK},
+	} {
+		if code := send(r, http.MethodGet, "/v1/owners/"+tc.owner+"/uploads", tc.token); code != tc.want {
+			t.Errorf("%s: status %d, want %d", tc.name, code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/password"
+)
+
+// checkPassword refuses a new password that breaks the password policy
+// with every rule it breaks listed, so the user can fix them at once.
+// personal lists details of the user the password must not contain.
+func (h *Handler) checkPassword(w http.ResponseWriter, r *http.Request, pw string, personal ...string) bool {
+	if h.passwords == nil {
+		return true
+	}
+	violations := h.passwords.Check(r.Context(), pw, personal...)
+	if len(violations) == 0 {
+		return true
+	}
+	fields := make([]FieldError, len(violations))
+	for i, v := range violations {
+		h.passwordRejects.Add(v.Rule, 1)
+		fields[i] = FieldError{Field: "password", Description: v.Message}
+	}
+	SendProblemResponse(w, r, ErrCodeValidation, "Password does not meet the password policy", fields...)
+	return false
+}
+
+// newPasswordPolicy builds the policy of cfg.
+func newPasswordPolicy(cfg config.PasswordConfig) *password.Policy {
+	p := &password.Policy{
+		MinLength:      cfg.MinLength,
+		MaxLength:      cfg.MaxLength,
+		MinClasses:     cfg.MinClasses,
+		MinEntropy:     cfg.MinEntropy,
+		BannedWords:    cfg.BannedWords,
+		MinBreachCount: cfg.MinBreachCount,
+	}
+	if cfg.BreachedDir != "" {
+		p.Breaches = password.RangeDir(cfg.BreachedDir)
+	}
+	return p
+}
@@ -0,0 +1,55 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// RangeDir looks passwords up in a directory of range files in the layout
+// of the Have I Been Pwned downloads: one file per first five hex digits
+// of the SHA-1 of a password, named after them with an optional .txt
+// extension, listing the remaining 35 digits and a count as
+// SUFFIX:COUNT per line. Only the file of one prefix is read per lookup,
+// so the full list never has to fit in memory.
+type RangeDir string
+
+func (d RangeDir) Breached(_ context.Context, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(d), prefix))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// Lists without any password of this prefix leave its file out.
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		s, count, ok := strings.Cut(line, ":")
+		if !ok || !strings.EqualFold(s, suffix) {
+			continue
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0, fmt.Errorf("password: %s: bad count in %q", f.Name(), line)
+		}
+		return n, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,195 @@
+// Package password decides whether a new password is strong enough before
+// it is sent to the user service.
+//
+// A Policy checks the length, the number of character classes, an estimate
+// of the entropy, words that must not appear, such as the user's own name
+// or email, and optionally whether the password is known from breaches.
+// Every rule that fails is reported with a message telling the user what
+// to change.
+package password
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation is a rule a password breaks.
+type Violation struct {
+	// Rule is stable and meant for metrics: length, classes, entropy,
+	// banned_word, personal_info or breached.
+	Rule    string
+	Message string
+}
+
+// BreachChecker reports how often a password appears in known breaches.
+type BreachChecker interface {
+	Breached(ctx context.Context, password string) (int, error)
+}
+
+// Policy holds the rules. Zero values switch a rule off.
+type Policy struct {
+	MinLength int
+	// MaxLength counts bytes rather than characters, as bcrypt, which the
+	// user service hashes passwords with, ignores anything past 72 bytes.
+	MaxLength int
+	// MinClasses is how many of lower case, upper case, digits and other
+	// characters must appear.
+	MinClasses int
+	// MinEntropy is the least estimated entropy in bits.
+	MinEntropy float64
+	// BannedWords may not appear anywhere in the password, ignoring case
+	// and common letter substitutions.
+	BannedWords []string
+	Breaches    BreachChecker
+	// MinBreachCount is how often a password has to appear in breaches to
+	// be refused.
+	MinBreachCount int
+}
+
+// minWordLength keeps short names and email parts from banning every
+// password that happens to contain them.
+const minWordLength = 3
+
+// Check returns the rules password breaks. personal lists the user's own
+// details, such as their name and email, which must not appear in it
+// either. A breach check that fails is logged and skipped, so an
+// unreadable breach list does not stop users from setting passwords.
+func (p *Policy) Check(ctx context.Context, password string, personal ...string) []Violation {
+	var vs []Violation
+	n := utf8.RuneCountInString(password)
+	if p.MinLength > 0 && n < p.MinLength {
+		vs = append(vs, Violation{"length", fmt.Sprintf("use at least %d characters", p.MinLength)})
+	}
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		vs = append(vs, Violation{"length", fmt.Sprintf("use at most %d bytes, fewer characters if some are not plain ASCII", p.MaxLength)})
+	}
+	if p.MinClasses > 0 && classes(password) < p.MinClasses {
+		vs = append(vs, Violation{"classes", fmt.Sprintf("mix at least %d of lower case letters, upper case letters, digits and symbols", p.MinClasses)})
+	}
+	if p.MinEntropy > 0 && Entropy(password) < p.MinEntropy {
+		vs = append(vs, Violation{"entropy", "make it longer or less predictable, avoid repeated characters and sequences such as abc or 123"})
+	}
+	normalized := normalize(password)
+	for _, w := range p.BannedWords {
+		if w = normalize(w); len(w) >= minWordLength && strings.Contains(normalized, w) {
+			vs = append(vs, Violation{"banned_word", "avoid common words such as " + strings.ToLower(w)})
+			break
+		}
+	}
+	for _, w := range personalWords(personal) {
+		if strings.Contains(normalized, w) {
+			vs = append(vs, Violation{"personal_info", "do not use your name or email address"})
+			break
+		}
+	}
+	if p.Breaches != nil && len(vs) == 0 {
+		count, err := p.Breaches.Breached(ctx, password)
+		switch {
+		case err != nil:
+			slog.ErrorContext(ctx, "error checking breached passwords", "err", err)
+		case count >= max(p.MinBreachCount, 1):
+			vs = append(vs, Violation{"breached", "this password has appeared in a data breach, choose a different one"})
+		}
+	}
+	return vs
+}
+
+// classes counts the character classes in s.
+func classes(s string) int {
+	var lower, upper, digit, other int
+	for _, r := range s {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
+
+// Entropy estimates the bits of entropy of s as its length times the bits
+// per character of the classes it uses. Characters that repeat the
+// previous one or continue a sequence, as in aaa, abc or 321, do not count
+// towards the length.
+func Entropy(s string) float64 {
+	pool := 0
+	var lower, upper, digit, symbol, other bool
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < utf8.RuneSelf:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	for _, c := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.used {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	length := 0
+	var prev, step rune
+	for i, r := range s {
+		d := r - prev
+		switch {
+		case i == 0:
+			length++
+		case d == 0 || (d == step && (d == 1 || d == -1)):
+			// Repeats and runs add nothing after their first step.
+		default:
+			length++
+		}
+		step, prev = d, r
+	}
+	return float64(length) * math.Log2(float64(pool))
+}
+
+// substitutions undoes common ways of disguising letters.
+var substitutions = strings.NewReplacer("0", "o", "1", "i", "!", "i", "3", "e", "4", "a", "@", "a", "5", "s", "$", "s", "7", "t")
+
+func normalize(s string) string {
+	return substitutions.Replace(strings.ToLower(s))
+}
+
+// personalWords splits names and email addresses into the words that may
+// not appear in a password.
+func personalWords(personal []string) []string {
+	var words []string
+	for _, s := range personal {
+		local, domain, _ := strings.Cut(s, "@")
+		parts := strings.FieldsFunc(local, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) })
+		if domain != "" {
+			// The first label of the domain, not the top level domain.
+			label, _, _ := strings.Cut(domain, ".")
+			parts = append(parts, label)
+		}
+		for _, part := range parts {
+			if w := normalize(part); utf8.RuneCountInString(w) >= minWordLength {
+				words = append(words, w)
+			}
+		}
+	}
+	return words
+}
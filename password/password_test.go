@@ -0,0 +1,157 @@
+package password
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func rules(vs []Violation) []string {
+	var names []string
+	for _, v := range vs {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func TestCheck(t *testing.T) {
+	p := &Policy{MinLength: 10, MaxLength: 72, MinClasses: 2, MinEntropy: 40, BannedWords: []string{"password", "qwerty"}}
+	for _, tc := range []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"strong", "Tulip-Harbor-91", nil},
+		{"too short", "Tu-91x", []string{"length", "entropy"}},
+		{"72 bytes", strings.Repeat("Ab1-", 18), nil},
+		{"73 bytes", strings.Repeat("Ab1-", 18) + "x", []string{"length"}},
+		// 38 characters take 75 bytes, past 72 of which bcrypt ignores
+		// the rest.
+		{"over 72 bytes in fewer characters", "Ž1" + strings.Repeat("žŁ", 18), []string{"length"}},
+		{"one class", "tulipharborxyz", []string{"classes"}},
+		{"non-ASCII letters count by case", "Ťulipharborž", nil},
+		{"symbols count as a class", "tulip harbor-", nil},
+		{"low entropy", "Aaaaaaaaaaaaaaaaaaa1", []string{"entropy"}},
+		{"run", "Abcdefghijklmnop1", []string{"entropy"}},
+		{"banned word", "MyPassword-2024", []string{"banned_word"}},
+		{"banned word disguised", "My-P@ssw0rd-2024", []string{"banned_word"}},
+		{"every rule broken", "pass", []string{"length", "classes", "entropy"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := rules(p.Check(context.Background(), tc.password)); !slices.Equal(got, tc.want) {
+				t.Errorf("Check(%q) broke %v, want %v", tc.password, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckPersonalInfo(t *testing.T) {
+	p := &Policy{}
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"Jordan-Tulip-91", []string{"personal_info"}},
+		{"Tulip-Instaupload-91", []string{"personal_info"}},
+		{"Tulip-j0rdan-91", []string{"personal_info"}},
+		// Short parts and the top level domain do not count.
+		{"Tulip-Ed-com-91", nil},
+	} {
+		if got := rules(p.Check(context.Background(), tc.password, "Ed Jordan", "ed.jordan@instaupload.com")); !slices.Equal(got, tc.want) {
+			t.Errorf("Check(%q) broke %v, want %v", tc.password, got, tc.want)
+		}
+	}
+}
+
+// breaches answers lookups with count or err, counting them.
+type breaches struct {
+	count, calls int
+	err          error
+}
+
+func (b *breaches) Breached(context.Context, string) (int, error) {
+	b.calls++
+	return b.count, b.err
+}
+
+func TestCheckBreaches(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		breaches *breaches
+		minCount int
+		want     []string
+	}{
+		{"not breached", &breaches{}, 0, nil},
+		{"breached", &breaches{count: 1}, 0, []string{"breached"}},
+		{"below min count", &breaches{count: 2}, 3, nil},
+		{"at min count", &breaches{count: 3}, 3, []string{"breached"}},
+		{"lookup fails", &breaches{count: 9, err: errors.New("disk gone")}, 0, nil},
+	} {
+		p := &Policy{Breaches: tc.breaches, MinBreachCount: tc.minCount}
+		if got := rules(p.Check(context.Background(), "Tulip-Harbor-91")); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: broke %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Passwords that already break a rule are not looked up.
+	b := &breaches{count: 1}
+	p := &Policy{MinLength: 10, Breaches: b}
+	if got := rules(p.Check(context.Background(), "short")); !slices.Equal(got, []string{"length"}) || b.calls != 0 {
+		t.Errorf("short password broke %v with %d lookups, want length and none", got, b.calls)
+	}
+}
+
+// writeRange adds the range file lines of passwords seen count times to
+// dir, naming it after the prefix with ext.
+func writeRange(t *testing.T, dir, ext string, passwords map[string]int, extra ...string) {
+	t.Helper()
+	files := map[string][]string{}
+	for pw, count := range passwords {
+		sum := sha1.Sum([]byte(pw))
+		hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+		files[hash[:5]] = append(files[hash[:5]], hash[5:]+":"+strconv.Itoa(count))
+	}
+	for prefix, lines := range files {
+		lines = append(extra, lines...)
+		if err := os.WriteFile(filepath.Join(dir, prefix+ext), []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRangeDir(t *testing.T) {
+	dir := t.TempDir()
+	writeRange(t, dir, ".txt", map[string]int{"hunter2": 7})
+	writeRange(t, dir, "", map[string]int{"correct horse": 3}, "0000000000000000000000000000000000A:1")
+	d := RangeDir(dir)
+
+	for pw, want := range map[string]int{
+		"hunter2":       7,
+		"correct horse": 3,
+		"Tulip-Harbor":  0,
+	} {
+		got, err := d.Breached(context.Background(), pw)
+		if err != nil || got != want {
+			t.Errorf("Breached(%q) = %d, %v, want %d", pw, got, err, want)
+		}
+	}
+}
+
+func TestRangeDirBadCount(t *testing.T) {
+	dir := t.TempDir()
+	sum := sha1.Sum([]byte("hunter2"))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]), []byte(hash[5:]+":many\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RangeDir(dir).Breached(context.Background(), "hunter2"); err == nil {
+		t.Error("bad count was not reported")
+	}
+}
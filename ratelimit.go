@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/InstaUpload/gateway/authctx"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/ratelimit"
+	"github.com/InstaUpload/gateway/session"
+)
+
+// rateLimit returns a middleware enforcing the limit configured under name.
+// Routes whose limit is not configured, or all routes when rate limiting is
+// off, are let through unchecked.
+func (h *Handler) rateLimit(name string) func(http.Handler) http.Handler {
+	cfg, ok := h.cfg.RateLimit.Limits[name]
+	if !ok || h.limiter == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	limit := ratelimit.Limit{
+		Algorithm: ratelimit.Algorithm(cfg.Algorithm),
+		Requests:  cfg.Requests,
+		Period:    cfg.Period,
+		Burst:     cfg.Burst,
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := name + ":" + h.rateLimitKey(r, cfg.Key)
+			res, err := h.limiter.Allow(r.Context(), key, limit)
+			if err != nil {
+				// A broken backend should not take the gateway down with it.
+				slog.ErrorContext(r.Context(), "error checking rate limit, letting request through", logging.RateLimit, "err", err)
+				h.metrics.RateLimited(name, "error")
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("RateLimit-Policy", limit.Policy())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			w.Header().Set("RateLimit-Reset", ceilSeconds(res.Reset))
+			if !res.Allowed {
+				h.metrics.RateLimited(name, "rejected")
+				w.Header().Set("Retry-After", ceilSeconds(res.RetryAfter))
+				SendProblemResponse(w, r, ErrCodeRateLimited, "")
+				return
+			}
+			h.metrics.RateLimited(name, "allowed")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey tells clients apart by kind, one of ip, user or api_key.
+// Requests without a user or a key listed in rate_limit.api_keys fall back
+// to their address.
+func (h *Handler) rateLimitKey(r *http.Request, kind string) string {
+	switch kind {
+	case "user":
+		if p, err := authctx.FromContext(r.Context()); err == nil {
+			return "user:" + strconv.FormatInt(p.UserID, 10)
+		}
+	case "api_key":
+		if key := r.Header.Get(h.cfg.RateLimit.APIKeyHeader); key != "" {
+			if hash := session.Hash(key); slices.Contains(h.cfg.RateLimit.APIKeys, hash) {
+				return "api_key:" + hash
+			}
+		}
+	}
+	return "ip:" + ratelimit.ClientIP(r, h.trustedProxies)
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
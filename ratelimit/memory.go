@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Memory keeps limits in process memory. Every replica counts on its own,
+// so with N replicas a client can make up to N times the configured rate;
+// use a shared Backend where that matters.
+type Memory struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	maxKeys   int
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval bounds how often Allow scans for idle keys.
+const sweepInterval = time.Minute
+
+// NewMemory tracks at most maxKeys keys. When full, idle keys are dropped
+// first and then arbitrary ones, which hands those clients a fresh quota.
+func NewMemory(maxKeys int) *Memory {
+	return &Memory{
+		buckets: map[string]*bucket{},
+		maxKeys: maxKeys,
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Allow(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return Result{}, fmt.Errorf("ratelimit: invalid limit %d per %s", limit.Requests, limit.Period)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.sweepLocked(now, false)
+	b, ok := m.buckets[key]
+	if !ok {
+		if len(m.buckets) >= m.maxKeys {
+			m.sweepLocked(now, true)
+		}
+		b = &bucket{}
+		m.buckets[key] = b
+	}
+	switch limit.Algorithm {
+	case TokenBucket:
+		return b.takeToken(limit, now), nil
+	case SlidingWindow:
+		return b.slideWindow(limit, now), nil
+	default:
+		return Result{}, fmt.Errorf("ratelimit: unknown algorithm %q", limit.Algorithm)
+	}
+}
+
+// Len returns the number of keys tracked.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.buckets)
+}
+
+// sweepLocked drops idle keys, at most once per sweepInterval unless full
+// is set, in which case it also makes room for one more key.
+func (m *Memory) sweepLocked(now time.Time, full bool) {
+	if !full && now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if !now.Before(b.idleAt) {
+			delete(m.buckets, key)
+		}
+	}
+	for key := range m.buckets {
+		if len(m.buckets) < m.maxKeys {
+			break
+		}
+		delete(m.buckets, key)
+	}
+}
@@ -0,0 +1,185 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestMemory returns a backend whose clock starts at the beginning of a
+// window of period.
+func newTestMemory(period time.Duration) (*Memory, *testClock) {
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC).Truncate(period)}
+	m := NewMemory(100)
+	m.now = clock.now
+	return m, clock
+}
+
+func allow(t *testing.T, m *Memory, key string, l Limit) Result {
+	t.Helper()
+	res, err := m.Allow(context.Background(), key, l)
+	if err != nil {
+		t.Fatalf("Allow(%q): %v", key, err)
+	}
+	return res
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	l := Limit{Algorithm: TokenBucket, Requests: 1, Period: time.Second, Burst: 3}
+	m, _ := newTestMemory(l.Period)
+
+	for i := range 3 {
+		res := allow(t, m, "k", l)
+		if !res.Allowed {
+			t.Fatalf("request %d of the burst refused", i+1)
+		}
+		if res.Limit != 3 || res.Remaining != 2-i {
+			t.Errorf("request %d: limit %d remaining %d, want 3 and %d", i+1, res.Limit, res.Remaining, 2-i)
+		}
+	}
+	res := allow(t, m, "k", l)
+	if res.Allowed {
+		t.Fatal("request over the burst allowed")
+	}
+	if res.RetryAfter != time.Second || res.Reset != 3*time.Second {
+		t.Errorf("retry after %s reset %s, want 1s and 3s", res.RetryAfter, res.Reset)
+	}
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	l := Limit{Algorithm: TokenBucket, Requests: 2, Period: time.Second, Burst: 2}
+	m, clock := newTestMemory(l.Period)
+	allow(t, m, "k", l)
+	allow(t, m, "k", l)
+
+	clock.advance(250 * time.Millisecond)
+	if res := allow(t, m, "k", l); res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Errorf("half a token in: allowed %v retry after %s, want refused for 250ms", res.Allowed, res.RetryAfter)
+	}
+	clock.advance(250 * time.Millisecond)
+	if !allow(t, m, "k", l).Allowed {
+		t.Error("refused once a token refilled")
+	}
+
+	// Idle time does not grow the bucket past its burst.
+	clock.advance(time.Hour)
+	for i := range 2 {
+		if !allow(t, m, "k", l).Allowed {
+			t.Fatalf("request %d after idling refused", i+1)
+		}
+	}
+	if allow(t, m, "k", l).Allowed {
+		t.Error("bucket refilled past its burst")
+	}
+}
+
+func TestSlidingWindowLimit(t *testing.T) {
+	l := Limit{Algorithm: SlidingWindow, Requests: 4, Period: 10 * time.Second}
+	m, clock := newTestMemory(l.Period)
+
+	for i := range 4 {
+		if !allow(t, m, "k", l).Allowed {
+			t.Fatalf("request %d refused", i+1)
+		}
+	}
+	res := allow(t, m, "k", l)
+	if res.Allowed {
+		t.Fatal("request over the limit allowed")
+	}
+	// The window fills up again once a quarter of the full previous window
+	// has slid out.
+	if want := 12500 * time.Millisecond; res.RetryAfter != want {
+		t.Errorf("retry after %s, want %s", res.RetryAfter, want)
+	}
+
+	clock.advance(12500*time.Millisecond - time.Millisecond)
+	if allow(t, m, "k", l).Allowed {
+		t.Error("allowed before the retry after passed")
+	}
+	clock.advance(time.Millisecond)
+	if !allow(t, m, "k", l).Allowed {
+		t.Error("refused once the retry after passed")
+	}
+}
+
+func TestSlidingWindowEdge(t *testing.T) {
+	l := Limit{Algorithm: SlidingWindow, Requests: 4, Period: 10 * time.Second}
+	m, clock := newTestMemory(l.Period)
+
+	// A full quota just before the edge of a window still counts just
+	// after it, where a fixed window would allow a second full quota.
+	clock.advance(9900 * time.Millisecond)
+	for range 4 {
+		allow(t, m, "k", l)
+	}
+	clock.advance(100 * time.Millisecond)
+	if allow(t, m, "k", l).Allowed {
+		t.Error("new window at the edge allowed a request over the limit")
+	}
+	clock.advance(5 * time.Second)
+	allowed := 0
+	for range 4 {
+		if allow(t, m, "k", l).Allowed {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("halfway into the window %d requests allowed, want 2", allowed)
+	}
+
+	// After two idle windows nothing carries over.
+	clock.advance(20 * time.Second)
+	for i := range 4 {
+		if !allow(t, m, "k", l).Allowed {
+			t.Fatalf("request %d after idling refused", i+1)
+		}
+	}
+}
+
+func TestKeysAreIsolated(t *testing.T) {
+	for _, algorithm := range []Algorithm{TokenBucket, SlidingWindow} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			l := Limit{Algorithm: algorithm, Requests: 2, Period: time.Minute}
+			m, _ := newTestMemory(l.Period)
+			allow(t, m, "a", l)
+			allow(t, m, "a", l)
+			if allow(t, m, "a", l).Allowed {
+				t.Fatal("key a allowed over its limit")
+			}
+			if res := allow(t, m, "b", l); !res.Allowed || res.Remaining != 1 {
+				t.Errorf("key b: allowed %v remaining %d, want a fresh quota", res.Allowed, res.Remaining)
+			}
+		})
+	}
+}
+
+func TestAllowInvalidLimit(t *testing.T) {
+	m, _ := newTestMemory(time.Second)
+	for _, l := range []Limit{
+		{Algorithm: TokenBucket, Requests: 0, Period: time.Second},
+		{Algorithm: TokenBucket, Requests: 1, Period: 0},
+		{Algorithm: "leaky_bucket", Requests: 1, Period: time.Second},
+	} {
+		if _, err := m.Allow(context.Background(), "k", l); err == nil {
+			t.Errorf("Allow with %+v: no error", l)
+		}
+	}
+}
+
+func TestMemoryMaxKeys(t *testing.T) {
+	l := Limit{Algorithm: TokenBucket, Requests: 1, Period: time.Minute}
+	m, _ := newTestMemory(l.Period)
+	m.maxKeys = 2
+	for _, key := range []string{"a", "b", "c"} {
+		allow(t, m, key, l)
+	}
+	if n := m.Len(); n != 2 {
+		t.Errorf("tracking %d keys, want at most 2", n)
+	}
+}
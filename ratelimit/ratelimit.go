@@ -0,0 +1,147 @@
+// Package ratelimit decides whether a client may make another request.
+//
+// Limits use either a token bucket, which allows short bursts on top of a
+// steady rate, or a sliding window counter, which caps the requests in any
+// window of the configured period. The state lives in a Backend; Memory
+// keeps it in process, other implementations can share it between gateway
+// replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type Algorithm string
+
+const (
+	TokenBucket   Algorithm = "token_bucket"
+	SlidingWindow Algorithm = "sliding_window"
+)
+
+// Limit allows Requests per Period. Burst is the bucket size of a token
+// bucket and defaults to Requests; sliding windows ignore it.
+type Limit struct {
+	Algorithm Algorithm
+	Requests  int
+	Period    time.Duration
+	Burst     int
+}
+
+func (l Limit) burst() int {
+	if l.Algorithm == TokenBucket && l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// Policy describes l in the RateLimit-Policy header format, e.g. 10;w=60.
+func (l Limit) Policy() string {
+	return fmt.Sprintf("%d;w=%d", l.burst(), int(math.Ceil(l.Period.Seconds())))
+}
+
+// Result is the outcome of one request against a limit.
+type Result struct {
+	Allowed bool
+	// Limit is the most requests a client can make at once.
+	Limit     int
+	Remaining int
+	// Reset is how long until the client has its full quota again.
+	Reset time.Duration
+	// RetryAfter is how long a refused client has to wait.
+	RetryAfter time.Duration
+}
+
+// Backend keeps the state of every key. Allow must count the request and
+// decide atomically, so concurrent requests of one key can not overshoot.
+type Backend interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// bucket is the state of one key. Token buckets use tokens and last; sliding
+// windows use the counts of the current and previous window.
+type bucket struct {
+	tokens float64
+	last   time.Time
+
+	windowStart time.Time
+	current     int
+	previous    int
+
+	// idleAt is when the key is back at its full quota and can be dropped.
+	idleAt time.Time
+}
+
+func (b *bucket) takeToken(l Limit, now time.Time) Result {
+	capacity := float64(l.burst())
+	rate := float64(l.Requests) / l.Period.Seconds()
+	if b.last.IsZero() {
+		b.tokens = capacity
+	} else {
+		b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+	b.last = now
+	res := Result{Limit: l.burst()}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((capacity - b.tokens) / rate)
+	b.idleAt = now.Add(res.Reset)
+	return res
+}
+
+func (b *bucket) slideWindow(l Limit, now time.Time) Result {
+	start := now.Truncate(l.Period)
+	switch {
+	case start.Equal(b.windowStart):
+	case start.Sub(b.windowStart) == l.Period:
+		b.previous, b.current = b.current, 0
+	default:
+		b.previous, b.current = 0, 0
+	}
+	b.windowStart = start
+	elapsed := now.Sub(start)
+	// The previous window counts in proportion to how much of it still
+	// overlaps the window ending now.
+	weight := 1 - float64(elapsed)/float64(l.Period)
+	estimate := float64(b.previous)*weight + float64(b.current)
+
+	res := Result{Limit: l.Requests}
+	if estimate+1 <= float64(l.Requests) {
+		b.current++
+		estimate++
+		res.Allowed = true
+	} else if b.current+1 > l.Requests {
+		// The current window alone is full: wait for it to become the
+		// previous window and slide out far enough.
+		need := 1 - float64(l.Requests-1)/float64(b.current)
+		res.RetryAfter = l.Period - elapsed + time.Duration(need*float64(l.Period))
+	} else {
+		// Wait until enough of the previous window has slid out.
+		need := 1 - float64(l.Requests-b.current-1)/float64(b.previous)
+		res.RetryAfter = time.Duration(need*float64(l.Period)) - elapsed
+	}
+	res.Remaining = max(l.Requests-int(math.Ceil(estimate)), 0)
+	// Requests of the current window count until the end of the next one.
+	switch {
+	case b.current > 0:
+		res.Reset = 2*l.Period - elapsed
+	case b.previous > 0:
+		res.Reset = l.Period - elapsed
+	}
+	if res.RetryAfter < 0 {
+		res.RetryAfter = 0
+	}
+	b.idleAt = start.Add(2 * l.Period)
+	return res
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/InstaUpload/gateway/config"
+	"github.com/InstaUpload/gateway/session"
+)
+
+func TestRateLimitKeyOnlyTrustsListedAPIKeys(t *testing.T) {
+	cfg := config.Default()
+	cfg.RateLimit.APIKeys = []string{session.Hash("issued-key")}
+	h := &Handler{cfg: cfg}
+
+	for _, tc := range []struct {
+		name, key, want string
+	}{
+		{"listed key", "issued-key", "api_key:" + session.Hash("issued-key")},
+		{"made up key", "made-up-key", "ip:192.0.2.1"},
+		{"no key", "", "ip:192.0.2.1"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/v1/users/me", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if tc.key != "" {
+			req.Header.Set(cfg.RateLimit.APIKeyHeader, tc.key)
+		}
+		if got := h.rateLimitKey(req, "api_key"); got != tc.want {
+			t.Errorf("%s: key %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package requestid gives every request an ID that follows it from the
+// HTTP client through the gateway logs to the user service.
+//
+// Middleware accepts the X-Request-ID of the client or makes one up,
+// echoes it in the response and stores it in the request context.
+// UnaryClientInterceptor sends it along with every gRPC call made with
+// that context, so the logs of the backends can be joined with those of
+// the gateway.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header is the HTTP header the ID is read from and echoed in.
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key the ID is sent in.
+	MetadataKey = "x-request-id"
+	// maxLength bounds IDs accepted from clients.
+	maxLength = 128
+)
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying id.
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID of ctx, or "" if it has none.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// New returns a random ID of 32 hex digits.
+func New() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("requestid: reading random bytes: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// Valid reports whether id may be taken over from a client: up to 128
+// letters, digits and the punctuation of common ID formats. Anything else
+// could smuggle line breaks or markup into logs and headers.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range []byte(id) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':', c == '/', c == '+', c == '=':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware stores the request ID in the context of every request and
+// sets it on the response. IDs sent by the client are kept if Valid, and
+// replaced by a new one otherwise.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+		if !Valid(id) {
+			id = New()
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// UnaryClientInterceptor adds the request ID of the call's context to its
+// outgoing metadata. Calls without one, such as those made at startup, are
+// sent unchanged.
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if id := FromContext(ctx); id != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, MetadataKey, id)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
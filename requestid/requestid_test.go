@@ -0,0 +1,88 @@
+package requestid
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestValid(t *testing.T) {
+	for id, want := range map[string]bool{
+		"7f1c2a9e0b":                     true,
+		"Root=1-5759e988-bd862e3fe1be46": true,
+		"a.b_c:d/e+f=":                   true,
+		strings.Repeat("a", 128):         true,
+		"":                               false,
+		strings.Repeat("a", 129):         false,
+		"abc\r\nSet-Cookie: a=b":         false,
+		"abc\ndef":                       false,
+		"a b":                            false,
+		"<script>":                       false,
+		`a"b`:                            false,
+		"ключ":                           false,
+	} {
+		if got := Valid(id); got != want {
+			t.Errorf("Valid(%.20q) = %v, want %v", id, got, want)
+		}
+	}
+}
+
+// serve runs Middleware for a request carrying id, unless it is empty,
+// and returns the ID the handler saw and the one echoed.
+func serve(id string) (seen, echoed string) {
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = FromContext(r.Context())
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if id != "" {
+		req.Header.Set(Header, id)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return seen, rec.Header().Get(Header)
+}
+
+func TestMiddlewareKeepsValidID(t *testing.T) {
+	if seen, echoed := serve("client-id-1"); seen != "client-id-1" || echoed != "client-id-1" {
+		t.Errorf("handler saw %q and response echoed %q, want the client's ID", seen, echoed)
+	}
+}
+
+func TestMiddlewareReplacesInvalidID(t *testing.T) {
+	for _, id := range []string{"", "bad\r\nid", strings.Repeat("a", 129)} {
+		seen, echoed := serve(id)
+		if seen == id || len(seen) != 32 || !Valid(seen) {
+			t.Errorf("header %.20q: handler saw %q, want a new ID", id, seen)
+		}
+		if echoed != seen {
+			t.Errorf("header %.20q: response echoed %q, want %q", id, echoed, seen)
+		}
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	var got []string
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		got = md.Get(MetadataKey)
+		return nil
+	}
+	intercept := UnaryClientInterceptor()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer t")
+	intercept(NewContext(ctx, "client-id-1"), "/api.UserService/AuthUser", nil, nil, nil, invoker)
+	if !slices.Equal(got, []string{"client-id-1"}) {
+		t.Errorf("metadata %s = %v, want the request ID", MetadataKey, got)
+	}
+
+	intercept(context.Background(), "/api.UserService/AuthUser", nil, nil, nil, invoker)
+	if len(got) != 0 {
+		t.Errorf("metadata %s = %v for a call without a request ID, want none", MetadataKey, got)
+	}
+}
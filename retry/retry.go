@@ -0,0 +1,233 @@
+// Package retry repeats gRPC calls that failed in a way the next attempt
+// may not, such as a backend that was briefly Unavailable.
+//
+// Each method has a Policy naming the status codes worth retrying and how
+// many attempts to make; methods without one are never retried. Attempts
+// are spaced by exponential backoff with full jitter and all fit in the
+// deadline of the call. A budget shared by every method, modelled on the
+// retry throttling of gRPC service configs, stops retries while too many
+// attempts fail, so a backend that is down is not sent several times the
+// load it already cannot take. Calls that are not safe to repeat are only
+// retried when they carry an idempotency key the backend deduplicates
+// them by.
+package retry
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/InstaUpload/gateway/logging"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// IdempotencyKeyHeader is the HTTP header clients send the key in.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotencyKeyMetadataKey is the gRPC metadata key it is sent on in.
+	IdempotencyKeyMetadataKey = "idempotency-key"
+	// maxKeyLength bounds keys accepted from clients.
+	maxKeyLength = 255
+)
+
+// ValidIdempotencyKey reports whether key may be taken over from a client:
+// up to 255 visible ASCII characters, the only ones gRPC metadata carries
+// unchanged.
+func ValidIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxKeyLength {
+		return false
+	}
+	for _, c := range []byte(key) {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// WithIdempotencyKey returns a copy of ctx whose calls send key, which
+// lets policies that RequireIdempotencyKey retry them.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, IdempotencyKeyMetadataKey, key)
+}
+
+func hasIdempotencyKey(ctx context.Context) bool {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	return len(md.Get(IdempotencyKeyMetadataKey)) > 0
+}
+
+// Policy is how the calls of a method are retried.
+type Policy struct {
+	// MaxAttempts counts the first attempt too; below 2 the method is not
+	// retried.
+	MaxAttempts int
+	// Codes are the status codes retried.
+	Codes []codes.Code
+	// RequireIdempotencyKey only retries calls made with a context from
+	// WithIdempotencyKey.
+	RequireIdempotencyKey bool
+}
+
+// Backoff spaces attempts.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// Delay returns the wait before retry n, counted from 1: a random
+// duration up to Initial times Multiplier to the power of n-1, capped at
+// Max.
+func (b Backoff) Delay(n int) time.Duration {
+	ceil := float64(b.Initial) * math.Pow(b.Multiplier, float64(n-1))
+	ceil = min(ceil, float64(b.Max))
+	return time.Duration(rand.Float64() * ceil)
+}
+
+// Budget bounds retries across all methods. It holds MaxTokens tokens to
+// start with; every failed attempt with a retried code takes one, every
+// successful call puts back TokenRatio, and retries are made only while
+// more than half of MaxTokens are left.
+type Budget struct {
+	MaxTokens  float64
+	TokenRatio float64
+}
+
+// Stats are the counters of a Retrier.
+type Stats struct {
+	// Retries counts retries by full method name.
+	Retries map[string]uint64
+	// Throttled counts calls by full method name that were not retried
+	// because the budget was spent.
+	Throttled map[string]uint64
+	// Tokens are the tokens left in the budget.
+	Tokens float64
+}
+
+// Retrier retries calls by the policy of their method.
+type Retrier struct {
+	policies map[string]Policy
+	budget   Budget
+	// delay is the Delay of the backoff, replaced in tests.
+	delay func(n int) time.Duration
+
+	mu        sync.Mutex
+	tokens    float64
+	retries   map[string]uint64
+	throttled map[string]uint64
+}
+
+// New returns a retrier with policies keyed by full method name, e.g.
+// /api.UserService/AuthUser.
+func New(policies map[string]Policy, backoff Backoff, budget Budget) *Retrier {
+	return &Retrier{
+		policies:  policies,
+		budget:    budget,
+		delay:     backoff.Delay,
+		tokens:    budget.MaxTokens,
+		retries:   map[string]uint64{},
+		throttled: map[string]uint64{},
+	}
+}
+
+// Stats returns a copy of the counters.
+func (r *Retrier) Stats() Stats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Stats{Retries: map[string]uint64{}, Throttled: map[string]uint64{}, Tokens: r.tokens}
+	for method, n := range r.retries {
+		s.Retries[method] = n
+	}
+	for method, n := range r.throttled {
+		s.Throttled[method] = n
+	}
+	return s
+}
+
+// UnaryClientInterceptor retries failed calls by the policy of their
+// method. A call is given up with the error of its last attempt once its
+// attempts are used up, the budget is spent, or the next attempt would
+// start after its deadline.
+func (r *Retrier) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p, ok := r.policies[method]
+		if !ok || p.MaxAttempts < 2 {
+			// Every call to the backend fills the budget, retried or not.
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil {
+				r.succeeded()
+			}
+			return err
+		}
+		// Calls that may not be repeated still count towards the budget.
+		keyed := !p.RequireIdempotencyKey || hasIdempotencyKey(ctx)
+		for attempt := 1; ; attempt++ {
+			err := invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil {
+				r.succeeded()
+				return nil
+			}
+			code := status.Code(err)
+			if !slices.Contains(p.Codes, code) {
+				return err
+			}
+			allowed := r.failed()
+			if attempt >= p.MaxAttempts || !keyed {
+				return err
+			}
+			if !allowed {
+				r.count(r.throttled, method)
+				slog.WarnContext(ctx, "retry budget spent, not retrying", logging.GRPCClient, "method", method, "code", code)
+				return err
+			}
+			delay := r.delay(attempt)
+			if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= delay {
+				return err
+			}
+			r.count(r.retries, method)
+			slog.DebugContext(ctx, "retrying call", logging.GRPCClient, "method", method, "attempt", attempt+1, "code", code, "delay", delay)
+			trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+				attribute.Int("attempt", attempt+1),
+				attribute.String("rpc.grpc.status_code", code.String()),
+			))
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// failed takes a token for a failed attempt and reports whether retries
+// are allowed.
+func (r *Retrier) failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = max(r.tokens-1, 0)
+	return r.tokens > r.budget.MaxTokens/2
+}
+
+// succeeded puts back part of a token for a successful call.
+func (r *Retrier) succeeded() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens = min(r.tokens+r.budget.TokenRatio, r.budget.MaxTokens)
+}
+
+func (r *Retrier) count(counts map[string]uint64, method string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	counts[method]++
+}
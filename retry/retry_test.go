@@ -0,0 +1,200 @@
+package retry
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	authUser   = "/api.UserService/AuthUser"
+	createUser = "/api.UserService/CreateUser"
+)
+
+var unavailable = []codes.Code{codes.Unavailable}
+
+// newTestRetrier returns a retrier that retries at once, with a budget
+// too large to run out unless a test makes it smaller.
+func newTestRetrier(budget Budget) *Retrier {
+	r := New(map[string]Policy{
+		authUser:   {MaxAttempts: 3, Codes: unavailable},
+		createUser: {MaxAttempts: 3, Codes: unavailable, RequireIdempotencyKey: true},
+	}, Backoff{}, budget)
+	r.delay = func(int) time.Duration { return 0 }
+	return r
+}
+
+var largeBudget = Budget{MaxTokens: 100, TokenRatio: 0.1}
+
+// backend answers calls with errs in turn and then with success,
+// counting the attempts.
+type backend struct {
+	errs     []error
+	attempts int
+}
+
+func (b *backend) invoke(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+	b.attempts++
+	if b.attempts <= len(b.errs) {
+		return b.errs[b.attempts-1]
+	}
+	return nil
+}
+
+// down returns a backend failing n times with code.
+func down(n int, code codes.Code) *backend {
+	b := &backend{}
+	for range n {
+		b.errs = append(b.errs, status.Error(code, "backend down"))
+	}
+	return b
+}
+
+func call(ctx context.Context, r *Retrier, method string, b *backend) error {
+	return r.UnaryClientInterceptor()(ctx, method, nil, nil, nil, b.invoke)
+}
+
+func TestRetrySucceeds(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	b := down(2, codes.Unavailable)
+	if err := call(context.Background(), r, authUser, b); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if b.attempts != 3 {
+		t.Errorf("%d attempts, want 3", b.attempts)
+	}
+	if n := r.Stats().Retries[authUser]; n != 2 {
+		t.Errorf("%d retries counted, want 2", n)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	b := down(10, codes.Unavailable)
+	err := call(context.Background(), r, authUser, b)
+	if status.Code(err) != codes.Unavailable {
+		t.Errorf("error %v, want the Unavailable of the last attempt", err)
+	}
+	if b.attempts != 3 {
+		t.Errorf("%d attempts, want MaxAttempts of 3", b.attempts)
+	}
+}
+
+func TestRetryOnlyPolicyCodes(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	if b := down(1, codes.InvalidArgument); call(context.Background(), r, authUser, b) == nil || b.attempts != 1 {
+		t.Errorf("InvalidArgument: %d attempts, want 1", b.attempts)
+	}
+	if b := down(1, codes.Unavailable); call(context.Background(), r, "/api.UserService/UpdateUserRole", b) == nil || b.attempts != 1 {
+		t.Errorf("method without a policy: %d attempts, want 1", b.attempts)
+	}
+}
+
+func TestRetryRequiresIdempotencyKey(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	b := down(1, codes.Unavailable)
+	if err := call(context.Background(), r, createUser, b); status.Code(err) != codes.Unavailable || b.attempts != 1 {
+		t.Errorf("CreateUser without a key: %d attempts and error %v, want 1 and Unavailable", b.attempts, err)
+	}
+
+	b = down(1, codes.Unavailable)
+	ctx := WithIdempotencyKey(context.Background(), "7f1c2a")
+	if err := call(ctx, r, createUser, b); err != nil || b.attempts != 2 {
+		t.Errorf("CreateUser with a key: %d attempts and error %v, want 2 and success", b.attempts, err)
+	}
+}
+
+func TestBudgetThrottles(t *testing.T) {
+	r := newTestRetrier(Budget{MaxTokens: 4, TokenRatio: 1})
+
+	// The first failure leaves 3 tokens, more than half of 4, so it is
+	// retried; the second leaves 2 and is not.
+	b := down(10, codes.Unavailable)
+	call(context.Background(), r, authUser, b)
+	if b.attempts != 2 {
+		t.Errorf("%d attempts, want 2 before the budget ran out", b.attempts)
+	}
+	s := r.Stats()
+	if s.Tokens != 2 || s.Throttled[authUser] != 1 || s.Retries[authUser] != 1 {
+		t.Errorf("stats %+v, want 2 tokens, 1 retry and 1 throttled call", s)
+	}
+
+	// Successful calls fill the budget up again.
+	for range 2 {
+		call(context.Background(), r, authUser, &backend{})
+	}
+	b = down(1, codes.Unavailable)
+	if err := call(context.Background(), r, authUser, b); err != nil || b.attempts != 2 {
+		t.Errorf("after successes: %d attempts and error %v, want a retry", b.attempts, err)
+	}
+}
+
+func TestBudgetCountsUnkeyedFailures(t *testing.T) {
+	r := newTestRetrier(Budget{MaxTokens: 4, TokenRatio: 1})
+	call(context.Background(), r, createUser, down(1, codes.Unavailable))
+	if tokens := r.Stats().Tokens; tokens != 3 {
+		t.Errorf("%v tokens after an unretried failure, want 3", tokens)
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	r.delay = func(int) time.Duration { return time.Hour }
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	b := down(1, codes.Unavailable)
+	start := time.Now()
+	err := call(ctx, r, authUser, b)
+	if status.Code(err) != codes.Unavailable || b.attempts != 1 {
+		t.Errorf("%d attempts and error %v, want 1 and Unavailable as the backoff ends after the deadline", b.attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %s, want at once", elapsed)
+	}
+	if n := r.Stats().Retries[authUser]; n != 0 {
+		t.Errorf("%d retries counted, want 0", n)
+	}
+}
+
+func TestRetryStopsWhenCanceled(t *testing.T) {
+	r := newTestRetrier(largeBudget)
+	r.delay = func(int) time.Duration { return time.Hour }
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &backend{errs: []error{status.Error(codes.Unavailable, "backend down")}}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if err := call(ctx, r, authUser, b); status.Code(err) != codes.Unavailable || b.attempts != 1 {
+		t.Errorf("%d attempts and error %v, want the backoff cut short", b.attempts, err)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	for n, ceil := range map[int]time.Duration{1: 100 * time.Millisecond, 3: 400 * time.Millisecond, 10: time.Second} {
+		for range 100 {
+			if d := b.Delay(n); d < 0 || d >= ceil {
+				t.Fatalf("Delay(%d) = %s, want below %s", n, d, ceil)
+			}
+		}
+	}
+}
+
+func TestValidIdempotencyKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"7f1c2a-00":              true,
+		strings.Repeat("k", 255): true,
+		"":                       false,
+		strings.Repeat("k", 256): false,
+		"a b":                    false,
+		"ключ":                   false,
+	} {
+		if got := ValidIdempotencyKey(key); got != want {
+			t.Errorf("ValidIdempotencyKey(%.10q) = %v, want %v", key, got, want)
+		}
+	}
+}
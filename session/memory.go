@@ -0,0 +1,153 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps sessions in process memory. Sessions are lost on
+// restart and are not shared between gateway replicas; use a shared Store
+// when running more than one instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	families  map[string]Family
+	byUser    map[int64]map[string]struct{}
+	refresh   map[string]RefreshToken
+	access    map[string]AccessToken
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval bounds how often writes scan for expired entries.
+const sweepInterval = time.Minute
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		families: map[string]Family{},
+		byUser:   map[int64]map[string]struct{}{},
+		refresh:  map[string]RefreshToken{},
+		access:   map[string]AccessToken{},
+		now:      time.Now,
+	}
+}
+
+func (s *MemoryStore) CreateFamily(_ context.Context, f Family) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+	s.families[f.ID] = f
+	if s.byUser[f.UserID] == nil {
+		s.byUser[f.UserID] = map[string]struct{}{}
+	}
+	s.byUser[f.UserID][f.ID] = struct{}{}
+	return nil
+}
+
+func (s *MemoryStore) GetFamily(_ context.Context, id string) (Family, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.families[id]
+	if !ok {
+		return Family{}, ErrNotFound
+	}
+	return f, nil
+}
+
+func (s *MemoryStore) RevokeFamily(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, ok := s.families[id]
+	if !ok {
+		return ErrNotFound
+	}
+	f.Revoked = true
+	s.families[id] = f
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserFamilies(_ context.Context, userID int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revoked := 0
+	for id := range s.byUser[userID] {
+		f := s.families[id]
+		if f.Revoked {
+			continue
+		}
+		f.Revoked = true
+		s.families[id] = f
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (s *MemoryStore) SaveRefresh(_ context.Context, t RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+	s.refresh[t.Hash] = t
+	return nil
+}
+
+func (s *MemoryStore) UseRefresh(_ context.Context, hash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.refresh[hash]
+	if !ok {
+		return RefreshToken{}, ErrNotFound
+	}
+	if t.Used {
+		return t, ErrTokenReused
+	}
+	t.Used = true
+	s.refresh[hash] = t
+	return t, nil
+}
+
+func (s *MemoryStore) SaveAccess(_ context.Context, t AccessToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweepLocked()
+	s.access[t.Hash] = t
+	return nil
+}
+
+func (s *MemoryStore) GetAccess(_ context.Context, hash string) (AccessToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.access[hash]
+	if !ok {
+		return AccessToken{}, ErrNotFound
+	}
+	return t, nil
+}
+
+// sweepLocked drops expired tokens and families. Used refresh tokens are
+// kept until they expire so reuse can still be detected.
+func (s *MemoryStore) sweepLocked() {
+	now := s.now()
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for h, t := range s.access {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.access, h)
+		}
+	}
+	for h, t := range s.refresh {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.refresh, h)
+		}
+	}
+	for id, f := range s.families {
+		if !now.Before(f.ExpiresAt) {
+			delete(s.families, id)
+			delete(s.byUser[f.UserID], id)
+			if len(s.byUser[f.UserID]) == 0 {
+				delete(s.byUser, f.UserID)
+			}
+		}
+	}
+}
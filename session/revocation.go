@@ -0,0 +1,151 @@
+package session
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// RevocationList remembers revoked tokens, by hash, until they would have
+// expired anyway, and per user cutoffs that revoke every token issued
+// before a point in time. It holds at most maxEntries tokens and as many
+// cutoffs; when full the entries closest to expiry are evicted first.
+type RevocationList struct {
+	mu            sync.Mutex
+	tokens        map[string]*revocation[string]
+	byExpiry      expiryHeap[string]
+	users         map[int64]*revocation[int64]
+	usersByExpiry expiryHeap[int64]
+	maxEntries    int
+	now           func() time.Time
+}
+
+// revocation is a revoked token, keyed by hash, or a user cutoff, keyed by
+// user ID.
+type revocation[K comparable] struct {
+	key K
+	// revokedAt is when the cutoff was set; tokens do not use it.
+	revokedAt time.Time
+	expiresAt time.Time
+	index     int
+}
+
+func NewRevocationList(maxEntries int) *RevocationList {
+	return &RevocationList{
+		tokens:     map[string]*revocation[string]{},
+		users:      map[int64]*revocation[int64]{},
+		maxEntries: maxEntries,
+		now:        time.Now,
+	}
+}
+
+// RevokeToken rejects the token with the given hash until expiresAt.
+func (l *RevocationList) RevokeToken(hash string, expiresAt time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if !now.Before(expiresAt) {
+		return
+	}
+	l.evictExpiredLocked(now)
+	if t, ok := l.tokens[hash]; ok {
+		if expiresAt.After(t.expiresAt) {
+			t.expiresAt = expiresAt
+			heap.Fix(&l.byExpiry, t.index)
+		}
+		return
+	}
+	add(l.tokens, &l.byExpiry, l.maxEntries, &revocation[string]{key: hash, expiresAt: expiresAt})
+}
+
+func (l *RevocationList) IsTokenRevoked(hash string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.tokens[hash]
+	return ok && l.now().Before(t.expiresAt)
+}
+
+// RevokeUser rejects every token of userID issued before now. The cutoff is
+// kept for maxTokenLifetime, after which those tokens have expired.
+func (l *RevocationList) RevokeUser(userID int64, maxTokenLifetime time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.evictExpiredLocked(now)
+	if c, ok := l.users[userID]; ok {
+		c.revokedAt = now
+		c.expiresAt = now.Add(maxTokenLifetime)
+		heap.Fix(&l.usersByExpiry, c.index)
+		return
+	}
+	add(l.users, &l.usersByExpiry, l.maxEntries, &revocation[int64]{key: userID, revokedAt: now, expiresAt: now.Add(maxTokenLifetime)})
+}
+
+// IsUserTokenRevoked reports whether a token of userID issued at issuedAt
+// falls before the user's cutoff. Issue times of JWTs are whole seconds,
+// so a token issued in the second of the cutoff counts as issued before
+// it. A zero issuedAt falls before every cutoff.
+func (l *RevocationList) IsUserTokenRevoked(userID int64, issuedAt time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.users[userID]
+	if !ok || !l.now().Before(c.expiresAt) {
+		return false
+	}
+	return !issuedAt.After(c.revokedAt)
+}
+
+// Len returns the number of revoked tokens currently tracked.
+func (l *RevocationList) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.tokens)
+}
+
+func (l *RevocationList) evictExpiredLocked(now time.Time) {
+	evictExpired(l.tokens, &l.byExpiry, now)
+	evictExpired(l.users, &l.usersByExpiry, now)
+}
+
+// add tracks r, first evicting the entries closest to expiry while there
+// are maxEntries or more.
+func add[K comparable](m map[K]*revocation[K], h *expiryHeap[K], maxEntries int, r *revocation[K]) {
+	for len(m) >= maxEntries && h.Len() > 0 {
+		oldest := heap.Pop(h).(*revocation[K])
+		delete(m, oldest.key)
+	}
+	heap.Push(h, r)
+	m[r.key] = r
+}
+
+func evictExpired[K comparable](m map[K]*revocation[K], h *expiryHeap[K], now time.Time) {
+	for h.Len() > 0 && !now.Before((*h)[0].expiresAt) {
+		r := heap.Pop(h).(*revocation[K])
+		delete(m, r.key)
+	}
+}
+
+// expiryHeap is a min-heap of revocations ordered by expiry.
+type expiryHeap[K comparable] []*revocation[K]
+
+func (h expiryHeap[K]) Len() int           { return len(h) }
+func (h expiryHeap[K]) Less(i, j int) bool { return h[i].expiresAt.Before(h[j].expiresAt) }
+func (h expiryHeap[K]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap[K]) Push(x any) {
+	r := x.(*revocation[K])
+	r.index = len(*h)
+	*h = append(*h, r)
+}
+
+func (h *expiryHeap[K]) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return r
+}
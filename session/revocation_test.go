@@ -0,0 +1,79 @@
+package session
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestRevocationList(maxEntries int) (*RevocationList, *testClock) {
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	l := NewRevocationList(maxEntries)
+	l.now = clock.now
+	return l, clock
+}
+
+func TestRevokeUserCutoff(t *testing.T) {
+	l, clock := newTestRevocationList(10)
+	before := clock.now().Add(-time.Minute)
+	l.RevokeUser(1, time.Hour)
+
+	if !l.IsUserTokenRevoked(1, before) {
+		t.Error("token issued before the cutoff is not revoked")
+	}
+	if !l.IsUserTokenRevoked(1, time.Time{}) {
+		t.Error("token without an issue time is not revoked")
+	}
+	if !l.IsUserTokenRevoked(1, clock.now()) {
+		t.Error("token issued in the second of the cutoff is not revoked")
+	}
+	if l.IsUserTokenRevoked(1, clock.now().Add(time.Second)) {
+		t.Error("token issued after the cutoff is revoked")
+	}
+	if l.IsUserTokenRevoked(2, before) {
+		t.Error("token of another user is revoked")
+	}
+
+	clock.advance(time.Hour)
+	if l.IsUserTokenRevoked(1, before) {
+		t.Error("cutoff still applies after it expired")
+	}
+}
+
+func TestRevokeUserBounded(t *testing.T) {
+	l, clock := newTestRevocationList(3)
+	for id := int64(1); id <= 5; id++ {
+		l.RevokeUser(id, time.Hour)
+		clock.advance(time.Second)
+	}
+	if n := len(l.users); n != 3 {
+		t.Fatalf("tracking %d cutoffs, want 3", n)
+	}
+	// The cutoffs closest to expiry, those set first, were evicted.
+	for id, want := range map[int64]bool{1: false, 2: false, 3: true, 4: true, 5: true} {
+		if got := l.IsUserTokenRevoked(id, time.Time{}); got != want {
+			t.Errorf("user %d revoked = %v, want %v", id, got, want)
+		}
+	}
+
+	// Setting a cutoff again moves it instead of adding one.
+	l.RevokeUser(3, time.Hour)
+	l.RevokeUser(6, time.Hour)
+	if !l.IsUserTokenRevoked(3, time.Time{}) || l.IsUserTokenRevoked(4, time.Time{}) {
+		t.Error("renewed cutoff was evicted before an older one")
+	}
+}
+
+func TestRevokeTokenBounded(t *testing.T) {
+	l, clock := newTestRevocationList(2)
+	for i := 1; i <= 3; i++ {
+		l.RevokeToken(strconv.Itoa(i), clock.now().Add(time.Duration(i)*time.Minute))
+	}
+	if l.Len() != 2 || l.IsTokenRevoked("1") || !l.IsTokenRevoked("3") {
+		t.Errorf("after 3 revocations with room for 2: len %d, 1 revoked %v, 3 revoked %v", l.Len(), l.IsTokenRevoked("1"), l.IsTokenRevoked("3"))
+	}
+	clock.advance(3 * time.Minute)
+	if l.IsTokenRevoked("3") {
+		t.Error("token still revoked after it expired")
+	}
+}
@@ -0,0 +1,246 @@
+// Package session issues the gateway's own short lived access tokens and
+// rotating refresh tokens on top of the token returned by the user service.
+//
+// A login starts a token family that keeps the user service token on the
+// gateway. Every refresh consumes the presented refresh token and issues a
+// new pair in the same family; presenting a consumed refresh token again is
+// treated as theft and revokes the whole family.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	AccessTokenPrefix  = "gwa_"
+	RefreshTokenPrefix = "gwr_"
+)
+
+var (
+	ErrNotFound        = errors.New("session: token not found")
+	ErrExpired         = errors.New("session: token expired")
+	ErrRevoked         = errors.New("session: token family revoked")
+	ErrTokenReused     = errors.New("session: refresh token reused")
+	ErrNotGatewayToken = errors.New("session: not a gateway token")
+	errEmptyToken      = errors.New("session: empty upstream token")
+)
+
+// Family is one login session and every token pair rotated from it.
+type Family struct {
+	ID            string
+	UserID        int64
+	UpstreamToken string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	Revoked       bool
+}
+
+// RefreshToken is stored by the hash of its value, never the value itself.
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+type AccessToken struct {
+	Hash      string
+	FamilyID  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Store persists families and tokens. Implementations must make UseRefresh
+// atomic so two concurrent refreshes can not both succeed.
+type Store interface {
+	CreateFamily(ctx context.Context, f Family) error
+	GetFamily(ctx context.Context, id string) (Family, error)
+	RevokeFamily(ctx context.Context, id string) error
+	// RevokeUserFamilies revokes every family of the user and returns how
+	// many were still active.
+	RevokeUserFamilies(ctx context.Context, userID int64) (int, error)
+	SaveRefresh(ctx context.Context, t RefreshToken) error
+	// UseRefresh marks the refresh token as used and returns it. It returns
+	// the token together with ErrTokenReused if it was already used.
+	UseRefresh(ctx context.Context, hash string) (RefreshToken, error)
+	SaveAccess(ctx context.Context, t AccessToken) error
+	GetAccess(ctx context.Context, hash string) (AccessToken, error)
+}
+
+// Pair is what a client receives on login and on every refresh.
+type Pair struct {
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	FamilyID         string
+}
+
+type Manager struct {
+	store      Store
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	now        func() time.Time
+}
+
+func NewManager(store Store, accessTTL, refreshTTL time.Duration) *Manager {
+	return &Manager{
+		store:      store,
+		accessTTL:  accessTTL,
+		refreshTTL: refreshTTL,
+		now:        time.Now,
+	}
+}
+
+// Start begins a new family for the user service token and issues its
+// first pair. The family can not outlive upstreamExpiry.
+func (m *Manager) Start(ctx context.Context, userID int64, upstreamToken string, upstreamExpiry time.Time) (Pair, error) {
+	if upstreamToken == "" {
+		return Pair{}, errEmptyToken
+	}
+	id, err := randomString(16)
+	if err != nil {
+		return Pair{}, err
+	}
+	now := m.now()
+	f := Family{
+		ID:            id,
+		UserID:        userID,
+		UpstreamToken: upstreamToken,
+		CreatedAt:     now,
+		ExpiresAt:     upstreamExpiry,
+	}
+	if err := m.store.CreateFamily(ctx, f); err != nil {
+		return Pair{}, err
+	}
+	return m.issue(ctx, f, now)
+}
+
+// Refresh consumes refreshToken and rotates it into a new pair. Reusing a
+// consumed refresh token revokes the family and returns ErrTokenReused
+// along with the ID of the revoked family.
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (Pair, Family, error) {
+	if !strings.HasPrefix(refreshToken, RefreshTokenPrefix) {
+		return Pair{}, Family{}, ErrNotFound
+	}
+	rt, err := m.store.UseRefresh(ctx, Hash(refreshToken))
+	if errors.Is(err, ErrTokenReused) {
+		if rerr := m.store.RevokeFamily(ctx, rt.FamilyID); rerr != nil {
+			return Pair{}, Family{ID: rt.FamilyID}, errors.Join(err, rerr)
+		}
+		return Pair{}, Family{ID: rt.FamilyID}, err
+	}
+	if err != nil {
+		return Pair{}, Family{}, err
+	}
+	f, err := m.store.GetFamily(ctx, rt.FamilyID)
+	if err != nil {
+		return Pair{}, Family{}, err
+	}
+	now := m.now()
+	switch {
+	case f.Revoked:
+		return Pair{}, f, ErrRevoked
+	case !now.Before(rt.ExpiresAt), !now.Before(f.ExpiresAt):
+		return Pair{}, f, ErrExpired
+	}
+	p, err := m.issue(ctx, f, now)
+	return p, f, err
+}
+
+// Resolve returns the family behind a gateway access token. Tokens without
+// the gateway prefix return ErrNotGatewayToken so callers can pass them through
+// to the user service unchanged.
+func (m *Manager) Resolve(ctx context.Context, accessToken string) (AccessToken, Family, error) {
+	if !strings.HasPrefix(accessToken, AccessTokenPrefix) {
+		return AccessToken{}, Family{}, ErrNotGatewayToken
+	}
+	at, err := m.store.GetAccess(ctx, Hash(accessToken))
+	if err != nil {
+		return AccessToken{}, Family{}, err
+	}
+	f, err := m.store.GetFamily(ctx, at.FamilyID)
+	if err != nil {
+		return AccessToken{}, Family{}, err
+	}
+	now := m.now()
+	switch {
+	case f.Revoked:
+		return at, f, ErrRevoked
+	case !now.Before(at.ExpiresAt), !now.Before(f.ExpiresAt):
+		return at, f, ErrExpired
+	}
+	return at, f, nil
+}
+
+// Revoke ends the family so none of its tokens can be used or refreshed.
+func (m *Manager) Revoke(ctx context.Context, familyID string) error {
+	return m.store.RevokeFamily(ctx, familyID)
+}
+
+// RevokeUser ends every family of userID and returns how many were active.
+func (m *Manager) RevokeUser(ctx context.Context, userID int64) (int, error) {
+	return m.store.RevokeUserFamilies(ctx, userID)
+}
+
+func (m *Manager) issue(ctx context.Context, f Family, now time.Time) (Pair, error) {
+	access, err := randomString(32)
+	if err != nil {
+		return Pair{}, err
+	}
+	refresh, err := randomString(32)
+	if err != nil {
+		return Pair{}, err
+	}
+	p := Pair{
+		AccessToken:      AccessTokenPrefix + access,
+		AccessExpiresAt:  earliest(now.Add(m.accessTTL), f.ExpiresAt),
+		RefreshToken:     RefreshTokenPrefix + refresh,
+		RefreshExpiresAt: earliest(now.Add(m.refreshTTL), f.ExpiresAt),
+		FamilyID:         f.ID,
+	}
+	if err := m.store.SaveAccess(ctx, AccessToken{
+		Hash:      Hash(p.AccessToken),
+		FamilyID:  f.ID,
+		IssuedAt:  now,
+		ExpiresAt: p.AccessExpiresAt,
+	}); err != nil {
+		return Pair{}, err
+	}
+	if err := m.store.SaveRefresh(ctx, RefreshToken{
+		Hash:      Hash(p.RefreshToken),
+		FamilyID:  f.ID,
+		ExpiresAt: p.RefreshExpiresAt,
+	}); err != nil {
+		return Pair{}, err
+	}
+	return p, nil
+}
+
+// Hash is the key tokens are stored under.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
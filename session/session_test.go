@@ -0,0 +1,136 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// testClock is a clock tests move by hand.
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time          { return c.t }
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestManager(t *testing.T) (*Manager, *testClock) {
+	t.Helper()
+	clock := &testClock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewMemoryStore()
+	store.now = clock.now
+	m := NewManager(store, 5*time.Minute, time.Hour)
+	m.now = clock.now
+	return m, clock
+}
+
+func start(t *testing.T, m *Manager, clock *testClock) Pair {
+	t.Helper()
+	p, err := m.Start(context.Background(), 42, "upstream", clock.now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	return p
+}
+
+func TestRefreshRotates(t *testing.T) {
+	ctx := context.Background()
+	m, clock := newTestManager(t)
+	first := start(t, m, clock)
+
+	second, f, err := m.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if f.UserID != 42 || f.UpstreamToken != "upstream" {
+		t.Errorf("family = %+v, want user 42 with the upstream token", f)
+	}
+	if second.FamilyID != first.FamilyID {
+		t.Errorf("family ID = %q, want %q", second.FamilyID, first.FamilyID)
+	}
+	if second.RefreshToken == first.RefreshToken || second.AccessToken == first.AccessToken {
+		t.Error("refresh returned the tokens it was given")
+	}
+	if _, _, err := m.Resolve(ctx, second.AccessToken); err != nil {
+		t.Errorf("Resolve new access token: %v", err)
+	}
+}
+
+func TestRefreshRejectsRotatedToken(t *testing.T) {
+	ctx := context.Background()
+	m, clock := newTestManager(t)
+	first := start(t, m, clock)
+	if _, _, err := m.Refresh(ctx, first.RefreshToken); err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+
+	_, f, err := m.Refresh(ctx, first.RefreshToken)
+	if !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("second Refresh error = %v, want ErrTokenReused", err)
+	}
+	if f.ID != first.FamilyID {
+		t.Errorf("revoked family = %q, want %q", f.ID, first.FamilyID)
+	}
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	ctx := context.Background()
+	m, clock := newTestManager(t)
+	first := start(t, m, clock)
+	second, _, err := m.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	// A second login of the same user is a family of its own.
+	other := start(t, m, clock)
+
+	if _, _, err := m.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrTokenReused) {
+		t.Fatalf("reuse error = %v, want ErrTokenReused", err)
+	}
+	if _, _, err := m.Refresh(ctx, second.RefreshToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Refresh with the latest token after reuse: error = %v, want ErrRevoked", err)
+	}
+	if _, _, err := m.Resolve(ctx, second.AccessToken); !errors.Is(err, ErrRevoked) {
+		t.Errorf("Resolve latest access token after reuse: error = %v, want ErrRevoked", err)
+	}
+	if _, _, err := m.Refresh(ctx, other.RefreshToken); err != nil {
+		t.Errorf("Refresh of another family: %v", err)
+	}
+}
+
+func TestRefreshExpired(t *testing.T) {
+	ctx := context.Background()
+	m, clock := newTestManager(t)
+	p := start(t, m, clock)
+
+	clock.advance(time.Hour)
+	if _, _, err := m.Refresh(ctx, p.RefreshToken); !errors.Is(err, ErrExpired) {
+		t.Errorf("Refresh at expiry: error = %v, want ErrExpired", err)
+	}
+}
+
+func TestRefreshExpiresWithUpstreamToken(t *testing.T) {
+	ctx := context.Background()
+	m, clock := newTestManager(t)
+	p, err := m.Start(ctx, 42, "upstream", clock.now().Add(10*time.Minute))
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if !p.RefreshExpiresAt.Equal(clock.now().Add(10 * time.Minute)) {
+		t.Errorf("refresh expires at %v, want the upstream expiry", p.RefreshExpiresAt)
+	}
+
+	clock.advance(10 * time.Minute)
+	if _, _, err := m.Refresh(ctx, p.RefreshToken); !errors.Is(err, ErrExpired) {
+		t.Errorf("Refresh after upstream expiry: error = %v, want ErrExpired", err)
+	}
+}
+
+func TestRefreshUnknownToken(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestManager(t)
+	for _, token := range []string{"", "not-a-gateway-token", RefreshTokenPrefix + "unknown"} {
+		if _, _, err := m.Refresh(ctx, token); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Refresh(%q) error = %v, want ErrNotFound", token, err)
+		}
+	}
+}
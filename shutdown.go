@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/InstaUpload/gateway/config"
+)
+
+// countInFlight keeps count of the requests being handled, so shutdown can
+// tell how many it waited for and how many it had to cut off.
+func (h *Handler) countInFlight(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.inFlight.Add(1)
+		defer h.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// shutdown stops servers in the order load balancers need. Readiness
+// fails first while requests are still served, for the drain delay; then
+// the servers stop accepting connections and wait up to the shutdown
+// timeout for the requests still running, which are cut off after that.
+func (h *Handler) shutdown(cfg config.HTTPConfig, servers ...*http.Server) {
+	slog.Info("shutting down, reporting not ready", "drain_delay", cfg.DrainDelay, "in_flight", h.inFlight.Load())
+	h.health.Stop()
+	for _, srv := range servers {
+		// Clients reconnect, and so move to another instance, after their
+		// next request.
+		srv.SetKeepAlivesEnabled(false)
+	}
+	time.Sleep(cfg.DrainDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	start := time.Now()
+	pending := h.inFlight.Load()
+	slog.Info("stopping http servers", "in_flight", pending, "timeout", cfg.ShutdownTimeout)
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = srv.Shutdown(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		aborted := h.inFlight.Load()
+		for _, srv := range servers {
+			srv.Close()
+		}
+		slog.Warn("requests did not finish in time, cut them off",
+			"drained", max(pending-aborted, 0), "aborted", aborted, "took", time.Since(start), "err", err)
+		return
+	}
+	slog.Info("http servers stopped", "drained", pending, "took", time.Since(start))
+}
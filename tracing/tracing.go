@@ -0,0 +1,93 @@
+// Package tracing records OpenTelemetry spans for the requests the gateway
+// serves and the gRPC calls it makes on their behalf.
+//
+// A Tracer starts a server span for every HTTP request, continuing the
+// trace of a W3C traceparent header but dropping any baggage header, and
+// a client span for every gRPC call, passing the trace on in the call's
+// metadata. NewProvider builds the provider spans are exported through;
+// tests can hand it an in-memory exporter from
+// go.opentelemetry.io/otel/sdk/trace/tracetest and assert the spans it
+// collects.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/InstaUpload/gateway/config"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer spans are recorded with.
+const instrumentation = "github.com/InstaUpload/gateway/tracing"
+
+// NewExporter returns the exporter cfg selects, writing to stdout when it
+// is stdout. It returns nil when tracing is disabled.
+func NewExporter(ctx context.Context, cfg config.TracingConfig, stdout io.Writer) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "none":
+		return nil, nil
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case "otlp":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		// The client connects lazily, so a collector that is down does not
+		// stop the gateway from starting.
+		return otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter %q", cfg.Exporter)
+	}
+}
+
+// NewProvider returns a provider that batches spans to exp and samples
+// traces at cfg.SampleRatio. Shut it down to flush the spans not yet
+// exported.
+//
+// Callers of the gateway are not trusted, so the sampled flag of their
+// traceparent is ignored: traces they start are sampled by trace ID like
+// the ones started at the gateway, and a caller can not make the gateway
+// record every request it sends. Spans started under a local span follow
+// its decision, so a request is recorded whole or not at all.
+func NewProvider(cfg config.TracingConfig, exp sdktrace.SpanExporter) *sdktrace.TracerProvider {
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName))
+	ratio := sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(ratio,
+			sdktrace.WithRemoteParentSampled(ratio),
+			sdktrace.WithRemoteParentNotSampled(ratio),
+		)),
+	)
+}
+
+// Tracer starts the spans of requests and gRPC calls.
+type Tracer struct {
+	tracer trace.Tracer
+	// ingress reads the trace of requests from untrusted callers.
+	ingress propagation.TextMapPropagator
+	// propagator passes the trace on to the user service.
+	propagator propagation.TextMapPropagator
+}
+
+// New returns a Tracer recording spans with tp. Requests continue the W3C
+// trace context of their caller but not its baggage, so callers can not
+// pass entries on to the user service; gRPC calls carry the trace context
+// and the baggage the gateway itself sets.
+func New(tp trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer:     tp.Tracer(instrumentation),
+		ingress:    propagation.TraceContext{},
+		propagator: propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}),
+	}
+}
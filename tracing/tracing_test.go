@@ -0,0 +1,194 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/InstaUpload/gateway/config"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	grpccodes "google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testTraceID    = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testParentSpan = "00f067aa0ba902b7"
+)
+
+// serve sends req through a router traced with a provider sampling at
+// ratio. The route makes one gRPC call, whose outgoing metadata is
+// returned with the spans that were recorded.
+func serve(t *testing.T, ratio float64, req *http.Request) (tracetest.SpanStubs, metadata.MD) {
+	t.Helper()
+	exp := tracetest.NewInMemoryExporter()
+	tp := NewProvider(config.TracingConfig{ServiceName: "gateway", SampleRatio: ratio}, exp)
+	tracer := New(tp)
+
+	var md metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		md, _ = metadata.FromOutgoingContext(ctx)
+		return nil
+	}
+	call := tracer.UnaryClientInterceptor()
+
+	r := chi.NewRouter()
+	r.Use(tracer.Middleware)
+	r.Post("/v1/users/send-editor-invite/{u}", func(w http.ResponseWriter, r *http.Request) {
+		if err := call(r.Context(), "/user.UserService/SendEditorInvite", nil, nil, nil, invoker); err != nil {
+			t.Errorf("call: %v", err)
+		}
+	})
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Shutting down would also clear the exporter.
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return exp.GetSpans(), md
+}
+
+func newRequest() *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/v1/users/send-editor-invite/jo", nil)
+}
+
+// find returns the span of the given kind.
+func find(t *testing.T, spans tracetest.SpanStubs, kind trace.SpanKind) tracetest.SpanStub {
+	t.Helper()
+	for _, s := range spans {
+		if s.SpanKind == kind {
+			return s
+		}
+	}
+	t.Fatalf("no %s span in %d spans", kind, len(spans))
+	return tracetest.SpanStub{}
+}
+
+func TestRequestSpans(t *testing.T) {
+	spans, md := serve(t, 1, newRequest())
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+
+	server := find(t, spans, trace.SpanKindServer)
+	if server.Name != "/v1/users/send-editor-invite/{u}" {
+		t.Errorf("server span named %q, want the route pattern", server.Name)
+	}
+	if server.Parent.IsValid() {
+		t.Errorf("server span has parent %s, want a new trace", server.Parent.SpanID())
+	}
+
+	client := find(t, spans, trace.SpanKindClient)
+	if client.Name != "user.UserService/SendEditorInvite" {
+		t.Errorf("client span named %q", client.Name)
+	}
+	if client.Parent.SpanID() != server.SpanContext.SpanID() || client.SpanContext.TraceID() != server.SpanContext.TraceID() {
+		t.Errorf("client span parent %s, want the server span %s", client.Parent.SpanID(), server.SpanContext.SpanID())
+	}
+
+	got := md.Get("traceparent")
+	want := "00-" + client.SpanContext.TraceID().String() + "-" + client.SpanContext.SpanID().String() + "-01"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("traceparent %q, want %q", got, want)
+	}
+}
+
+func TestRequestContinuesTrace(t *testing.T) {
+	req := newRequest()
+	req.Header.Set("traceparent", "00-"+testTraceID+"-"+testParentSpan+"-01")
+	spans, md := serve(t, 1, req)
+
+	server := find(t, spans, trace.SpanKindServer)
+	if server.SpanContext.TraceID().String() != testTraceID || server.Parent.SpanID().String() != testParentSpan {
+		t.Errorf("server span in trace %s under %s, want %s under %s",
+			server.SpanContext.TraceID(), server.Parent.SpanID(), testTraceID, testParentSpan)
+	}
+	if got := md.Get("traceparent"); len(got) != 1 || !strings.Contains(got[0], testTraceID) {
+		t.Errorf("traceparent %q, want trace %s", got, testTraceID)
+	}
+}
+
+func TestRequestIgnoresCallerSampling(t *testing.T) {
+	req := newRequest()
+	req.Header.Set("traceparent", "00-"+testTraceID+"-"+testParentSpan+"-01")
+	spans, md := serve(t, 0, req)
+	if len(spans) != 0 {
+		t.Errorf("recorded %d spans of a trace the caller sampled, want the ratio of 0 to hold", len(spans))
+	}
+	// The trace is still passed on, marked as not sampled.
+	if got := md.Get("traceparent"); len(got) != 1 || !strings.HasSuffix(got[0], "-00") {
+		t.Errorf("traceparent %q, want the trace passed on unsampled", got)
+	}
+}
+
+func TestRequestDropsBaggage(t *testing.T) {
+	req := newRequest()
+	req.Header.Set("baggage", "tenant=admin,role=root")
+	_, md := serve(t, 1, req)
+	if got := md.Get("baggage"); len(got) != 0 {
+		t.Errorf("baggage %q passed on to the user service", got)
+	}
+}
+
+func TestClientInterceptor(t *testing.T) {
+	exp := tracetest.NewInMemoryExporter()
+	tp := NewProvider(config.TracingConfig{ServiceName: "gateway", SampleRatio: 1}, exp)
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	call := New(tp).UnaryClientInterceptor()
+
+	var sent metadata.MD
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		sent, _ = metadata.FromOutgoingContext(ctx)
+		return status.Error(grpccodes.Unavailable, "backend down")
+	}
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-request-id", "req-1")
+	if err := call(ctx, "/user.UserService/AuthUser", nil, nil, nil, invoker); status.Code(err) != grpccodes.Unavailable {
+		t.Fatalf("error %v, want the Unavailable of the invoker", err)
+	}
+	parent.End()
+	if err := tp.ForceFlush(context.Background()); err != nil {
+		t.Fatalf("ForceFlush: %v", err)
+	}
+
+	client := find(t, exp.GetSpans(), trace.SpanKindClient)
+	if client.Name != "user.UserService/AuthUser" {
+		t.Errorf("client span named %q", client.Name)
+	}
+	if client.Parent.SpanID() != parent.SpanContext().SpanID() || client.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("client span parent %s, want the span of the call's context %s", client.Parent.SpanID(), parent.SpanContext().SpanID())
+	}
+	if client.Status.Code != codes.Error {
+		t.Errorf("failed call has span status %v, want error", client.Status.Code)
+	}
+	var code attribute.Value
+	for _, a := range client.Attributes {
+		if a.Key == semconv.RPCGRPCStatusCodeKey {
+			code = a.Value
+		}
+	}
+	if code.AsInt64() != int64(grpccodes.Unavailable) {
+		t.Errorf("span status code attribute %v, want %d", code.Emit(), grpccodes.Unavailable)
+	}
+
+	want := "00-" + client.SpanContext.TraceID().String() + "-" + client.SpanContext.SpanID().String() + "-01"
+	if got := sent.Get("traceparent"); len(got) != 1 || got[0] != want {
+		t.Errorf("traceparent %q, want %q", got, want)
+	}
+	if got := sent.Get("x-request-id"); len(got) != 1 || got[0] != "req-1" {
+		t.Errorf("x-request-id %q, want the metadata of the call kept", got)
+	}
+	if md, _ := metadata.FromOutgoingContext(ctx); len(md.Get("traceparent")) != 0 {
+		t.Error("traceparent written into the metadata of the caller's context")
+	}
+}
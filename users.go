@@ -1,17 +1,18 @@
 package main
 
 import (
-	"context"
-	"encoding/json"
 	"errors"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
 	pb "github.com/InstaUpload/common/api"
-	common "github.com/InstaUpload/common/types"
-	"github.com/go-chi/chi"
+	"github.com/InstaUpload/gateway/logging"
+	"github.com/InstaUpload/gateway/retry"
+	"github.com/InstaUpload/gateway/session"
+	"github.com/go-chi/chi/v5"
+	"google.golang.org/grpc/codes"
 )
 
 type MessageResponse struct {
@@ -19,40 +20,82 @@ type MessageResponse struct {
 }
 
 type CreateUserRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=100"`
+	Email    string `json:"email" validate:"required,email,max=254"`
+	Password string `json:"password" validate:"required,min=10,max=72"`
 }
 
 // CreateUser godoc
 //
 //	@Summary		Create User
-//	@Description	Create a new user
+//	@Description	Create a new user. The password must meet the password policy; every rule it breaks is listed in the 400 response. With enumeration protection on, which is the default, the response is the same whether or not the email is already taken, 201 or 202 when create_accepted is set, and is sent no sooner than the latency budget.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
-//	@Param			user	body		CreateUserRequest	true	"User details"
-//	@Success		201		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Param			user			body		CreateUserRequest	true	"User details"
+//	@Param			Idempotency-Key	header		string				false	"Lets the gateway retry the call to the user service, which deduplicates sign ups by it"
+//	@Success		201				{object}	MessageResponse
+//	@Success		202				{object}	MessageResponse
+//	@Failure		400				{object}	ProblemDetails
+//	@Failure		409				{object}	ProblemDetails
+//	@Failure		429				{object}	ProblemDetails
+//	@Failure		500				{object}	ProblemDetails
+//	@Failure		503				{object}	ProblemDetails
 //	@Router			/v1/users/create [post]
 func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
-	decoder := json.NewDecoder(r.Body)
-	var user pb.CreateUserRequest
-	if err := decoder.Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("error decoding request: ", err)
+	var req CreateUserRequest
+	if !decodeJSON(w, r, &req) {
 		return
 	}
+	if !h.checkPassword(w, r, req.Password, req.Name, req.Email) {
+		return
+	}
+	if key := r.Header.Get(retry.IdempotencyKeyHeader); key != "" {
+		if !retry.ValidIdempotencyKey(key) {
+			SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{
+				Field:       retry.IdempotencyKeyHeader,
+				Description: retry.IdempotencyKeyHeader + " must be 1 to 255 visible ASCII characters",
+			})
+			return
+		}
+		ctx = retry.WithIdempotencyKey(ctx, key)
+	}
+	user := pb.CreateUserRequest{
+		Name:     req.Name,
+		Email:    req.Email,
+		Password: req.Password,
+	}
 	grpcResp, err := h.userClient.CreateUser(ctx, &user)
+	if h.outcomes != nil {
+		// Answer the same whether or not the email is already taken.
+		switch code := grpcStatus(err).Code(); {
+		case err == nil:
+			h.recordOutcome(r, "create", "created")
+		case code == codes.AlreadyExists:
+			h.recordOutcome(r, "create", "exists")
+		default:
+			h.recordOutcome(r, "create", "error")
+			sendGRPCError(w, r, err, "creating user", nil)
+			return
+		}
+		status := http.StatusCreated
+		if h.cfg.Auth.Enumeration.CreateAccepted {
+			status = http.StatusAccepted
+		}
+		SendJsonResponse(w, status, MessageResponse{
+			Message: "Sign up received, check your email to continue",
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
-		log.Println("error creating user: ", err)
+		sendGRPCError(w, r, err, "creating user", map[codes.Code]httpError{
+			codes.AlreadyExists: {ErrCodeUserExists, ""},
+		})
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 	resp := MessageResponse{
 		Message: "User created successfully",
 	}
@@ -60,79 +103,279 @@ func (h *Handler) CreateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 type LoginUserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,max=254"`
+	Password string `json:"password" validate:"required,max=1024"`
 }
 
 // LoginUser godoc
 //
 //	@Summary		Login User
-//	@Description	Login to an existing user
+//	@Description	Login to an existing user. The access token is returned in the body and, when enabled, also set as a Secure, HttpOnly cookie. After a failed login the email and client address have to wait before trying again, and repeated failures lock them out for a while; such attempts get a 429 with Retry-After.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
 //	@Param			user	body		LoginUserRequest	true	"User login details"
-//	@Success		200		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Success		200		{object}	TokenResponse
+//	@Header			200		{string}	Set-Cookie	"Access token cookie, only when enabled"
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Router			/v1/users/login [post]
 func (h *Handler) LoginUser(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 
+	var login LoginUserRequest
+	if !decodeJSON(w, r, &login) {
+		return
+	}
+
+	keys := h.loginKeys(r, login.Email)
+	attempt, ok := h.checkLoginLockout(w, r, keys)
+	if !ok {
+		return
+	}
+	// Attempts that end without a verdict on the password do not count.
+	defer attempt.Release()
+
 	user := pb.LoginUserRequest{
-		Email:    "gpt.sahaj28@gmail.com",
-		Password: "password123",
+		Email:    login.Email,
+		Password: login.Password,
 	}
 	grpcResp, err := h.userClient.LoginUser(ctx, &user)
 	if err != nil {
-		http.Error(w, "Failed to login user", http.StatusInternalServerError)
-		log.Println("error logging in user: ", err)
+		switch grpcStatus(err).Code() {
+		case codes.InvalidArgument, codes.NotFound, codes.Unauthenticated, codes.PermissionDenied:
+			h.recordLoginFailure(r.Context(), attempt)
+		}
+		// Do not tell the client whether the email or the password was wrong.
+		invalid := httpError{ErrCodeInvalidCredentials, "Invalid email or password"}
+		sendGRPCError(w, r, err, "logging in user", map[codes.Code]httpError{
+			codes.InvalidArgument:  invalid,
+			codes.NotFound:         invalid,
+			codes.Unauthenticated:  invalid,
+			codes.PermissionDenied: invalid,
+		})
+		return
+	}
+	h.recordLoginSuccess(attempt, keys)
+	now := time.Now()
+	token := newTokenResponse(grpcResp.Token, now, h.cfg.Auth.UpstreamTokenTTL)
+	if h.sessions != nil {
+		// Keep the user service token on the gateway and hand out a short
+		// lived access token with a refresh token instead.
+		userID, err := h.loginUserID(ctx, grpcResp.Token)
+		if err != nil {
+			sendGRPCError(w, r, err, "authenticating new login", nil)
+			return
+		}
+		pair, err := h.sessions.Start(ctx, userID, grpcResp.Token, token.ExpiresAt)
+		if err != nil {
+			slog.ErrorContext(ctx, "error starting session", logging.Auth, "err", err)
+			SendProblemResponse(w, r, ErrCodeInternal, "")
+			return
+		}
+		token = newSessionTokenResponse(pair, now)
+	}
+	h.setTokenCookie(w, token)
+	w.Header().Set("Cache-Control", "no-store")
+	SendJsonResponse(w, http.StatusOK, token)
+}
+
+// RefreshToken godoc
+//
+//	@Summary		Refresh Token
+//	@Description	Exchange a refresh token for a new access and refresh token. Every refresh token can be used once; using one again revokes the whole session. The token is read from the body or, when cookies are enabled, from the refresh token cookie.
+//	@Tags			Users
+//	@Accept			json
+//	@Produce		json
+//	@Param			data	body		RefreshTokenRequest	false	"Refresh token"
+//	@Success		200		{object}	TokenResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
+//	@Router			/v1/users/token/refresh [post]
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := h.grpcContext(r)
+	defer cancel()
+
+	refresh, ok := h.refreshToken(w, r)
+	if !ok {
+		return
+	}
+	if refresh == "" {
+		SendProblemResponse(w, r, ErrCodeValidation, "", FieldError{Field: "refresh_token", Description: "refresh_token is required"})
+		return
+	}
+	pair, family, err := h.sessions.Refresh(ctx, refresh)
+	switch {
+	case errors.Is(err, session.ErrTokenReused):
+		slog.WarnContext(ctx, "refresh token reused, revoked session family", logging.Auth, "family_id", family.ID, "user_id", family.UserID)
+		SendProblemResponse(w, r, ErrCodeRefreshReused, "This refresh token was already used, log in again")
+		return
+	case errors.Is(err, session.ErrExpired):
+		SendProblemResponse(w, r, ErrCodeRefreshInvalid, "Refresh token expired")
+		return
+	case errors.Is(err, session.ErrNotFound), errors.Is(err, session.ErrRevoked):
+		SendProblemResponse(w, r, ErrCodeRefreshInvalid, "")
+		return
+	case err != nil:
+		slog.ErrorContext(ctx, "error refreshing session", logging.Auth, "err", err)
+		SendProblemResponse(w, r, ErrCodeInternal, "")
+		return
+	}
+	// The session can not outlive the user service token behind it.
+	if _, err := h.userClient.AuthUser(ctx, &pb.AuthUserRequest{Token: family.UpstreamToken}); err != nil {
+		switch grpcStatus(err).Code() {
+		case codes.Unauthenticated, codes.InvalidArgument, codes.NotFound:
+			if err := h.sessions.Revoke(ctx, family.ID); err != nil {
+				slog.ErrorContext(ctx, "error revoking session", logging.Auth, "err", err)
+			}
+			SendProblemResponse(w, r, ErrCodeRefreshInvalid, "Session is no longer valid, log in again")
+		default:
+			sendGRPCError(w, r, err, "authenticating refreshed session", nil)
+		}
+		return
+	}
+	token := newSessionTokenResponse(pair, time.Now())
+	h.setTokenCookie(w, token)
+	w.Header().Set("Cache-Control", "no-store")
+	SendJsonResponse(w, http.StatusOK, token)
+}
+
+// LogoutUser godoc
+//
+//	@Summary		Logout User
+//	@Description	Revoke the token used for this request. For gateway sessions the refresh token is revoked too.
+//	@Tags			Users
+//	@Produce		json
+//	@Success		200	{object}	MessageResponse
+//	@Failure		401	{object}	ProblemDetails
+//	@Failure		429	{object}	ProblemDetails
+//	@Failure		500	{object}	ProblemDetails
+//	@Security		ApiKeyAuth
+//	@Router			/v1/users/logout [post]
+func (h *Handler) LogoutUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentPrincipal(w, r)
+	if !ok {
 		return
 	}
-	r.Header.Set("Authorization", "Bearer "+grpcResp.Token)
-	log.Printf("Response: %v", grpcResp)
-	SendJsonResponse(w, http.StatusOK, grpcResp)
+	info := user.Token
+	h.revoked.RevokeToken(info.Hash, info.ExpiresAt)
+	h.invalidateAuthToken(info.Hash)
+	if info.FamilyID != "" {
+		if err := h.sessions.Revoke(r.Context(), info.FamilyID); err != nil && !errors.Is(err, session.ErrNotFound) {
+			slog.ErrorContext(r.Context(), "error revoking session", logging.Auth, "err", err)
+			SendProblemResponse(w, r, ErrCodeInternal, "")
+			return
+		}
+	}
+	h.clearTokenCookies(w)
+	resp := MessageResponse{
+		Message: "Logged out",
+	}
+	SendJsonResponse(w, http.StatusOK, resp)
+}
+
+// LogoutAllUser godoc
+//
+//	@Summary		Logout User Everywhere
+//	@Description	Revoke every session of the current user on the gateway, including the token used for this request.
+//	@Tags			Users
+//	@Produce		json
+//	@Success		200	{object}	MessageResponse
+//	@Failure		401	{object}	ProblemDetails
+//	@Failure		429	{object}	ProblemDetails
+//	@Failure		500	{object}	ProblemDetails
+//	@Security		ApiKeyAuth
+//	@Router			/v1/users/logout-all [post]
+func (h *Handler) LogoutAllUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+	h.revoked.RevokeToken(user.Token.Hash, user.Token.ExpiresAt)
+	// Session tokens issued before now are rejected even if the store that
+	// holds them is slow to catch up.
+	h.revoked.RevokeUser(user.UserID, h.cfg.Auth.UpstreamTokenTTL)
+	h.invalidateAuthUser(user.UserID)
+	if h.sessions != nil {
+		n, err := h.sessions.RevokeUser(r.Context(), user.UserID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "error revoking sessions", logging.Auth, "err", err)
+			SendProblemResponse(w, r, ErrCodeInternal, "")
+			return
+		}
+		slog.InfoContext(r.Context(), "revoked sessions", logging.Auth, "count", n)
+	}
+	h.clearTokenCookies(w)
+	resp := MessageResponse{
+		Message: "Logged out of all sessions",
+	}
+	SendJsonResponse(w, http.StatusOK, resp)
 }
 
 // VerifyUser godoc
 //
 //	@Summary		Verify User
-//	@Description	Verify a existing user
+//	@Description	Verify a existing user. With enumeration protection on, which is the default, an unknown user and an expired token both get 401 auth.token_invalid, no sooner than the latency budget.
 //	@Tags			Users
 //	@Produce		json
 //	@Param			token	query		string	true	"Token send to user's mail for verification"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		401		{object}	MessageResponse
-//	@Failure		404		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		404		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Router			/v1/users/verify [get]
 func (h *Handler) VerifyUser(w http.ResponseWriter, r *http.Request) {
 	// Get token from query string.
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		http.Error(w, "Token is needed.", http.StatusBadRequest)
-		log.Println("error logging in user: ")
+		SendProblemResponse(w, r, ErrCodeMissingParameter, "Token is needed.")
+		slog.InfoContext(r.Context(), "token not provided", logging.Auth)
 		return
 	}
 	req := pb.VerifyUserRequest{
 		Token: token,
 	}
-	grpcResp, err := h.userClient.VerifyUser(r.Context(), &req)
+	ctx, cancel := h.grpcContext(r)
+	defer cancel()
+	grpcResp, err := h.userClient.VerifyUser(ctx, &req)
 	if err != nil {
-		if errors.Is(err, common.ErrIncorrectDataReceived) {
-			http.Error(w, "Token is expired", http.StatusUnauthorized)
+		if h.outcomes != nil {
+			// Do not tell an unknown user from an expired token.
+			invalid := httpError{ErrCodeTokenInvalid, "Invalid or expired token"}
+			switch grpcStatus(err).Code() {
+			case codes.NotFound:
+				h.recordOutcome(r, "verify", "not_found")
+			case codes.InvalidArgument, codes.Unauthenticated:
+				h.recordOutcome(r, "verify", "expired")
+			default:
+				h.recordOutcome(r, "verify", "error")
+			}
+			sendGRPCError(w, r, err, "verifying user", map[codes.Code]httpError{
+				codes.InvalidArgument: invalid,
+				codes.Unauthenticated: invalid,
+				codes.NotFound:        invalid,
+			})
 			return
 		}
-		if errors.Is(err, common.ErrDataNotFound) {
-			http.Error(w, "User not found or invalid token", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Failed to send verification to user", http.StatusInternalServerError)
-		log.Println("error sending verification token to user: ", err)
+		sendGRPCError(w, r, err, "verifying user", map[codes.Code]httpError{
+			codes.InvalidArgument: {ErrCodeTokenExpired, "Token is expired"},
+			codes.Unauthenticated: {ErrCodeTokenExpired, "Token is expired"},
+			codes.NotFound:        {ErrCodeUserNotFound, "User not found or invalid token"},
+		})
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	h.recordOutcome(r, "verify", "verified")
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 	resp := struct {
 		Message string `json:"message"`
 	}{
@@ -150,24 +393,30 @@ func (h *Handler) VerifyUser(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			token	query		string	true	"Token send to user's mail for verification"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Security		ApiKeyAuth
 //	@Router			/v1/users/send-verify [get]
 func (h *Handler) SendVerifyUser(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	user, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 
-	// Get Current user from ctx, and pass it in SendVerificationUserRequest.
+	// Pass the current user in SendVerificationUserRequest.
 	req := pb.SendVerificationUserRequest{
-		CurrentUser: ctx.Value(common.CurrentUserKey).(*pb.AuthUserResponse),
+		CurrentUser: user.User(),
 	}
 	grpcResp, err := h.userClient.SendVerificationUser(ctx, &req)
 	if err != nil {
-		http.Error(w, "Failed to send verification to user", http.StatusInternalServerError)
-		log.Println("error sending verification token to user: ", err)
+		sendGRPCError(w, r, err, "sending verification token to user", nil)
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 	resp := struct {
 		Message string `json:"message"`
 	}{
@@ -177,43 +426,54 @@ func (h *Handler) SendVerifyUser(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpdateUserRoleRequest struct {
-	UserID   string `json:"user_id"`
-	RoleName string `json:"role_name"`
+	UserID   int64  `json:"userId" validate:"required,min=1"`
+	RoleName string `json:"role_name" validate:"required,oneof=admin editor user"`
 }
 
 // UpdateUserRole godoc
 //
 //	@Summary		Update User Role
-//	@Description	Update the role of an existing user
+//	@Description	Update the role of an existing user. Requires the users:update_role permission.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
 //	@Param			data	body		UpdateUserRoleRequest	true	"User ID and role name"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		403		{object}	ProblemDetails
+//	@Failure		404		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Security		ApiKeyAuth
 //	@Router			/v1/users/update-role [put]
 func (h *Handler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	user, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
-	// get user id and role name from request body.
-	decoder := json.NewDecoder(r.Body)
-	var req pb.UpdateUserRoleRequest
-	if err := decoder.Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("error decoding request: ", err)
+	var body UpdateUserRoleRequest
+	if !decodeJSON(w, r, &body) {
 		return
 	}
-	// Get Current user from ctx, and pass it in UpdateUserRoleRequest.
-	req.CurrentUser = ctx.Value(common.CurrentUserKey).(*pb.AuthUserResponse)
+	req := pb.UpdateUserRoleRequest{
+		CurrentUser: user.User(),
+		UserId:      body.UserID,
+		RoleName:    body.RoleName,
+	}
 	grpcResp, err := h.userClient.UpdateUserRole(ctx, &req)
 	if err != nil {
-		http.Error(w, "Failed to update user role", http.StatusInternalServerError)
-		log.Println("error updating user role: ", err)
+		sendGRPCError(w, r, err, "updating user role", map[codes.Code]httpError{
+			codes.NotFound: {ErrCodeUserNotFound, "User or role not found"},
+		})
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	// Cached AuthUser responses still carry the old role.
+	h.invalidateAuthUser(req.UserId)
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 	resp := MessageResponse{
 		Message: "User role updated successfully",
 	}
@@ -229,17 +489,20 @@ func (h *Handler) UpdateUserRole(w http.ResponseWriter, r *http.Request) {
 //	@Produce		json
 //	@Param			token	query		string	true	"Token for adding editor user"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Security		ApiKeyAuth
 //	@Router			/v1/users/add-editor [post]
 func (h *Handler) AddEditorUser(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		http.Error(w, "Token is needed.", http.StatusBadRequest)
-		log.Println("Token not provided")
+		SendProblemResponse(w, r, ErrCodeMissingParameter, "Token is needed.")
+		slog.InfoContext(r.Context(), "token not provided", logging.Auth)
 		return
 	}
 	req := pb.AddEditorUserRequest{
@@ -247,8 +510,9 @@ func (h *Handler) AddEditorUser(w http.ResponseWriter, r *http.Request) {
 	}
 	_, err := h.userClient.AddEditorUser(ctx, &req)
 	if err != nil {
-		http.Error(w, "Failed to add editor user", http.StatusInternalServerError)
-		log.Println("error adding editor user: ", err)
+		sendGRPCError(w, r, err, "adding editor user", map[codes.Code]httpError{
+			codes.InvalidArgument: {ErrCodeTokenInvalid, "Invalid or expired token"},
+		})
 		return
 	}
 	resp := MessageResponse{
@@ -260,34 +524,44 @@ func (h *Handler) AddEditorUser(w http.ResponseWriter, r *http.Request) {
 // SendEditorInvite godoc
 //
 //	@Summary		Send Editor Invite
-//	@Description	Send an invite to a user to become an editor
+//	@Description	Send an invite to a user to become an editor. Requires the editors:invite permission.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
 //	@Param			u	path		int64	true	"User ID to send editor invite"
 //	@Success		200	{object}	MessageResponse
-//	@Failure		400	{object}	MessageResponse
-//	@Failure		500	{object}	MessageResponse
+//	@Failure		400	{object}	ProblemDetails
+//	@Failure		401	{object}	ProblemDetails
+//	@Failure		403	{object}	ProblemDetails
+//	@Failure		404	{object}	ProblemDetails
+//	@Failure		429	{object}	ProblemDetails
+//	@Failure		500	{object}	ProblemDetails
+//	@Failure		503	{object}	ProblemDetails
 //	@Security		ApiKeyAuth
 //	@Router			/v1/users/send-editor-invite/{u} [put]
 func (h *Handler) SendEditorInvite(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	user, ok := currentPrincipal(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 	uId := chi.URLParam(r, "u")
 	userId, err := strconv.ParseInt(uId, 10, 64)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
-		log.Println("error parsing user ID: ", err)
+		SendProblemResponse(w, r, ErrCodeMissingParameter, "Invalid user ID")
+		slog.InfoContext(r.Context(), "error parsing user ID", logging.HTTP, "err", err)
 		return
 	}
 	req := pb.SendEditorUserRequest{
 		UserId:      userId,
-		CurrentUser: ctx.Value(common.CurrentUserKey).(*pb.AuthUserResponse),
+		CurrentUser: user.User(),
 	}
 	_, err = h.userClient.SendEditorUser(ctx, &req)
 	if err != nil {
-		http.Error(w, "Failed to send editor invite", http.StatusInternalServerError)
-		log.Println("error sending editor invite: ", err)
+		sendGRPCError(w, r, err, "sending editor invite", map[codes.Code]httpError{
+			codes.NotFound: {ErrCodeUserNotFound, ""},
+		})
 		return
 	}
 	resp := MessageResponse{
@@ -297,40 +571,61 @@ func (h *Handler) SendEditorInvite(w http.ResponseWriter, r *http.Request) {
 }
 
 type ResetUserPasswordRequest struct {
-	Email string `json:"email"`
+	Email string `json:"email" validate:"required,email,max=254"`
 }
 
 // ResetUserPassword godoc
 //
 //	@Summary		Reset User Password
-//	@Description	Reset the password of an existing user
+//	@Description	Reset the password of an existing user. With enumeration protection on, which is the default, the response is the same whether or not an account uses the email, and is sent no sooner than the latency budget.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
 //	@Param			data	body		ResetUserPasswordRequest	true	"User email to reset password"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		404		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Router			/v1/users/reset-password [post]
 func (h *Handler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 
-	var req pb.ResetUserPasswordRequest
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("error decoding request: ", err)
+	var body ResetUserPasswordRequest
+	if !decodeJSON(w, r, &body) {
 		return
 	}
+	req := pb.ResetUserPasswordRequest{
+		Email: body.Email,
+	}
 
 	grpcResp, err := h.userClient.ResetUserPassword(ctx, &req)
+	if h.outcomes != nil {
+		// Answer the same whether or not an account has the email.
+		switch code := grpcStatus(err).Code(); {
+		case err == nil:
+			h.recordOutcome(r, "reset_password", "sent")
+		case code == codes.NotFound:
+			h.recordOutcome(r, "reset_password", "not_found")
+		default:
+			h.recordOutcome(r, "reset_password", "error")
+			sendGRPCError(w, r, err, "resetting user password", nil)
+			return
+		}
+		SendJsonResponse(w, http.StatusOK, MessageResponse{
+			Message: "If an account uses this email, a password reset email has been sent",
+		})
+		return
+	}
 	if err != nil {
-		http.Error(w, "Failed to reset user password", http.StatusInternalServerError)
-		log.Println("error resetting user password: ", err)
+		sendGRPCError(w, r, err, "resetting user password", map[codes.Code]httpError{
+			codes.NotFound: {ErrCodeUserNotFound, ""},
+		})
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 
 	resp := MessageResponse{
 		Message: "Password reset successfully",
@@ -339,49 +634,57 @@ func (h *Handler) ResetUserPassword(w http.ResponseWriter, r *http.Request) {
 }
 
 type UpdateUserPasswordRequest struct {
-	Password string `json:"password"`
+	Password string `json:"password" validate:"required,min=10,max=72"`
 }
 
 // UpdateUserPassword godoc
 //
 //	@Summary		Update User Password
-//	@Description	Update the password of an existing user
+//	@Description	Update the password of an existing user. The password must meet the password policy; every rule it breaks is listed in the 400 response.
 //	@Tags			Users
 //	@Accept			json
 //	@Produce		json
 //	@Param			token	query		string						true	"Token for updating user password"
 //	@Param			data	body		UpdateUserPasswordRequest	true	"New password for the user"
 //	@Success		200		{object}	MessageResponse
-//	@Failure		400		{object}	MessageResponse
-//	@Failure		500		{object}	MessageResponse
+//	@Failure		400		{object}	ProblemDetails
+//	@Failure		401		{object}	ProblemDetails
+//	@Failure		429		{object}	ProblemDetails
+//	@Failure		500		{object}	ProblemDetails
+//	@Failure		503		{object}	ProblemDetails
 //	@Router			/v1/users/update-password [post]
 func (h *Handler) UpdateUserPassword(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	ctx, cancel := h.grpcContext(r)
 	defer cancel()
 	// Get token from query string.
 	token := r.URL.Query().Get("token")
 	if token == "" {
-		http.Error(w, "Token is needed.", http.StatusBadRequest)
-		log.Println("Token not provided")
+		SendProblemResponse(w, r, ErrCodeMissingParameter, "Token is needed.")
+		slog.InfoContext(r.Context(), "token not provided", logging.Auth)
 		return
 	}
 
-	var req pb.UpdateUserPasswordRequest
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-		log.Println("error decoding request: ", err)
+	var body UpdateUserPasswordRequest
+	if !decodeJSON(w, r, &body) {
 		return
 	}
-	req.Token = token
+	if !h.checkPassword(w, r, body.Password) {
+		return
+	}
+	req := pb.UpdateUserPasswordRequest{
+		Token:    token,
+		Password: body.Password,
+	}
 
 	grpcResp, err := h.userClient.UpdateUserPassword(ctx, &req)
 	if err != nil {
-		http.Error(w, "Failed to update user password", http.StatusInternalServerError)
-		log.Println("error updating user password: ", err)
+		sendGRPCError(w, r, err, "updating user password", map[codes.Code]httpError{
+			codes.InvalidArgument: {ErrCodeTokenInvalid, "Invalid or expired token"},
+			codes.Unauthenticated: {ErrCodeTokenInvalid, "Invalid or expired token"},
+		})
 		return
 	}
-	log.Printf("Response: %v", grpcResp)
+	slog.DebugContext(r.Context(), "user service response", logging.GRPCClient, "response", grpcResp)
 
 	resp := MessageResponse{
 		Message: "Password updated successfully",
@@ -1,6 +1,11 @@
 package utils
 
-import "os"
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
 
 func GetEnvString(key string, defaultValue string) string {
 	value, exists := os.LookupEnv(key)
@@ -9,3 +14,43 @@ func GetEnvString(key string, defaultValue string) string {
 	}
 	return value
 }
+
+// GetEnvDuration reads key as a time.Duration such as "5s" or "1m30s".
+// An unset variable yields defaultValue, a malformed one an error naming the key.
+func GetEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := GetEnvString(key, "")
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %q is not a valid duration (e.g. 5s, 1m)", key, value)
+	}
+	return d, nil
+}
+
+// GetEnvInt reads key as a base 10 integer.
+func GetEnvInt(key string, defaultValue int) (int, error) {
+	value := GetEnvString(key, "")
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %q is not a valid integer", key, value)
+	}
+	return i, nil
+}
+
+// GetEnvBool reads key as a boolean (1, t, true, 0, f, false, ...).
+func GetEnvBool(key string, defaultValue bool) (bool, error) {
+	value := GetEnvString(key, "")
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s: %q is not a valid boolean", key, value)
+	}
+	return b, nil
+}
@@ -0,0 +1,173 @@
+// Package validate checks the fields of request structs against their
+// validate tags, e.g.
+//
+//	Email string `json:"email" validate:"required,email,max=254"`
+//
+// Rules are separated by commas and checked in order; the first one a
+// field breaks is reported and the rest of that field is skipped. Fields
+// are named after their json tag.
+//
+//	required   the field is not its zero value
+//	email      a plain address such as name@example.com, without a display name
+//	min=N      strings have at least N characters, numbers are at least N
+//	max=N      strings have at most N characters, numbers are at most N
+//	oneof=a b  the value is one of the space separated words
+//
+// Empty fields only break required, so optional fields can carry rules for
+// when they are given.
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// FieldError describes the rule a field breaks.
+type FieldError struct {
+	Field       string
+	Description string
+}
+
+type rule struct {
+	name string
+	// num is arg parsed for min and max.
+	num int64
+	// words is arg split for oneof.
+	words []string
+}
+
+type field struct {
+	index int
+	name  string
+	rules []rule
+}
+
+var cache sync.Map // reflect.Type -> []field
+
+// Struct checks v, a struct or a pointer to one, and returns an error for
+// every field that breaks a rule. It panics on a malformed tag, which is a
+// programming error.
+func Struct(v any) []FieldError {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	var errs []FieldError
+	for _, f := range fields(rv.Type()) {
+		fv := rv.Field(f.index)
+		for _, r := range f.rules {
+			if msg := check(r, fv); msg != "" {
+				errs = append(errs, FieldError{Field: f.name, Description: f.name + " " + msg})
+				break
+			}
+		}
+	}
+	return errs
+}
+
+func fields(t reflect.Type) []field {
+	if fs, ok := cache.Load(t); ok {
+		return fs.([]field)
+	}
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("validate")
+		if !ok || tag == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "" {
+			name = sf.Name
+		}
+		f := field{index: i, name: name}
+		for _, spec := range strings.Split(tag, ",") {
+			f.rules = append(f.rules, parseRule(t, sf, spec))
+		}
+		fs = append(fs, f)
+	}
+	cache.Store(t, fs)
+	return fs
+}
+
+func parseRule(t reflect.Type, sf reflect.StructField, spec string) rule {
+	name, arg, _ := strings.Cut(spec, "=")
+	r := rule{name: name}
+	switch name {
+	case "required":
+	case "email", "oneof":
+		if sf.Type.Kind() != reflect.String {
+			panic(fmt.Sprintf("validate: %s.%s: %s needs a string field", t, sf.Name, name))
+		}
+		r.words = strings.Fields(arg)
+		if name == "oneof" && len(r.words) == 0 {
+			panic(fmt.Sprintf("validate: %s.%s: oneof needs values", t, sf.Name))
+		}
+	case "min", "max":
+		n, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: %s.%s: %s needs a number, got %q", t, sf.Name, name, arg))
+		}
+		switch sf.Type.Kind() {
+		case reflect.String, reflect.Slice, reflect.Map,
+			reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		default:
+			panic(fmt.Sprintf("validate: %s.%s: %s does not apply to %s", t, sf.Name, name, sf.Type.Kind()))
+		}
+		r.num = n
+	default:
+		panic(fmt.Sprintf("validate: %s.%s: unknown rule %q", t, sf.Name, name))
+	}
+	return r
+}
+
+// check returns why v breaks r, or "" if it does not.
+func check(r rule, v reflect.Value) string {
+	if v.IsZero() {
+		if r.name == "required" {
+			return "is required"
+		}
+		return ""
+	}
+	switch r.name {
+	case "email":
+		addr, err := mail.ParseAddress(v.String())
+		if err != nil || addr.Address != v.String() {
+			return "must be a valid email address"
+		}
+	case "oneof":
+		for _, w := range r.words {
+			if v.String() == w {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(r.words, ", ")
+	case "min":
+		if n, unit := size(v); n < r.num {
+			return fmt.Sprintf("must be at least %d%s", r.num, unit)
+		}
+	case "max":
+		if n, unit := size(v); n > r.num {
+			return fmt.Sprintf("must be at most %d%s", r.num, unit)
+		}
+	}
+	return ""
+}
+
+// size returns the length of strings in characters and the value of
+// numbers, with the unit to report it in.
+func size(v reflect.Value) (int64, string) {
+	switch v.Kind() {
+	case reflect.String:
+		return int64(utf8.RuneCountInString(v.String())), " characters"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint()), ""
+	default:
+		return int64(v.Len()), " items"
+	}
+}
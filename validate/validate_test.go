@@ -0,0 +1,96 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+type signUp struct {
+	Name  string `json:"name" validate:"required,min=2,max=5"`
+	Email string `json:"email,omitempty" validate:"email"`
+	Role  string `json:"role" validate:"oneof=creator editor"`
+	Age   int    `json:"age" validate:"min=13,max=120"`
+	Note  string
+}
+
+func valid() signUp {
+	return signUp{Name: "Jo", Email: "jo@example.com", Role: "editor", Age: 30}
+}
+
+func TestStruct(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(*signUp)
+		want   []FieldError
+	}{
+		{"valid", func(*signUp) {}, nil},
+		{"required", func(s *signUp) { s.Name = "" }, []FieldError{{"name", "name is required"}}},
+		{"email", func(s *signUp) { s.Email = "jo@" }, []FieldError{{"email", "email must be a valid email address"}}},
+		{"email with a display name", func(s *signUp) { s.Email = "Jo <jo@example.com>" }, []FieldError{{"email", "email must be a valid email address"}}},
+		{"string min", func(s *signUp) { s.Name = "J" }, []FieldError{{"name", "name must be at least 2 characters"}}},
+		{"string max", func(s *signUp) { s.Name = "Joanna" }, []FieldError{{"name", "name must be at most 5 characters"}}},
+		// Five characters take ten bytes, which max counts as five.
+		{"string length in runes", func(s *signUp) { s.Name = "ŽŽŽŽŽ" }, nil},
+		{"int min", func(s *signUp) { s.Age = 12 }, []FieldError{{"age", "age must be at least 13"}}},
+		{"int max", func(s *signUp) { s.Age = 121 }, []FieldError{{"age", "age must be at most 120"}}},
+		{"oneof", func(s *signUp) { s.Role = "admin" }, []FieldError{{"role", "role must be one of creator, editor"}}},
+		{"empty fields only break required", func(s *signUp) { *s = signUp{} }, []FieldError{{"name", "name is required"}}},
+		{"every field reported", func(s *signUp) { s.Name, s.Role = "J", "admin" }, []FieldError{
+			{"name", "name must be at least 2 characters"},
+			{"role", "role must be one of creator, editor"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := valid()
+			tc.change(&s)
+			got := Struct(&s)
+			if len(got) != len(tc.want) {
+				t.Fatalf("errors %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("error %d: %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestStructFirstBrokenRuleOnly(t *testing.T) {
+	s := struct {
+		Email string `json:"email" validate:"email,max=3"`
+	}{Email: "not an address"}
+	if got := Struct(s); len(got) != 1 || got[0].Description != "email must be a valid email address" {
+		t.Errorf("errors %v, want only the email rule", got)
+	}
+}
+
+func TestStructPanicsOnMalformedTag(t *testing.T) {
+	for name, v := range map[string]any{
+		"unknown rule": &struct {
+			A string `validate:"omitempty"`
+		}{},
+		"min without number": &struct {
+			A string `validate:"min=ten"`
+		}{},
+		"max on a bool": &struct {
+			A bool `validate:"max=1"`
+		}{},
+		"email on an int": &struct {
+			A int `validate:"email"`
+		}{},
+		"oneof without words": &struct {
+			A string `validate:"oneof="`
+		}{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if msg, ok := r.(string); !ok || !strings.HasPrefix(msg, "validate: ") {
+					t.Errorf("recovered %v, want a validate panic", r)
+				}
+			}()
+			Struct(v)
+		})
+	}
+}